@@ -0,0 +1,73 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"strconv"
+	"strings"
+)
+
+// BoolMode is the set of words accepted as bool values.
+type BoolMode int
+
+const (
+	// Words accepted by strconv.ParseBool: 1, t, true, 0, f, false, etc.
+	BOOL_STRICT BoolMode = iota
+	// Also yes/no, on/off and enabled/disabled in any case.
+	BOOL_EXTENDED
+)
+
+// Extra words accepted in BOOL_EXTENDED mode.
+var extendedBoolWords = map[string]bool{
+	"yes":      true,
+	"no":       false,
+	"on":       true,
+	"off":      false,
+	"enabled":  true,
+	"disabled": false,
+}
+
+// SetBoolMode sets the words accepted as bool values by Bool, MustBool,
+// Bools and MapTo, which is BOOL_STRICT by default.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetBoolMode(mode BoolMode) {
+	c.boolMode = mode
+}
+
+// RegisterBoolWords registers case-insensitive words accepted as the given
+// bool value in every mode, e.g. RegisterBoolWords(true, "y", "enable").
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) RegisterBoolWords(value bool, words ...string) {
+	if c.boolWords == nil {
+		c.boolWords = make(map[string]bool)
+	}
+	for _, word := range words {
+		c.boolWords[strings.ToLower(word)] = value
+	}
+}
+
+// parseBool converts value to bool by the mode and registered words.
+func (c *ConfigFile) parseBool(value string) (bool, error) {
+	word := strings.ToLower(value)
+	if b, ok := c.boolWords[word]; ok {
+		return b, nil
+	}
+	if c.boolMode == BOOL_EXTENDED {
+		if b, ok := extendedBoolWords[word]; ok {
+			return b, nil
+		}
+	}
+	return strconv.ParseBool(value)
+}
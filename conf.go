@@ -17,11 +17,11 @@ package goconfig
 
 import (
 	"fmt"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -38,13 +38,12 @@ const (
 	ERR_KEY_NOT_FOUND
 	ERR_BLANK_SECTION_NAME
 	ERR_COULD_NOT_PARSE
+	ERR_UNDEFINED_VARIABLE
+	ERR_INTERPOLATION_CYCLE
 )
 
 var LineBreak = "\n"
 
-// Variable regexp pattern: %(variable)s
-var varPattern = regexp.MustCompile(`%\(([^\)]+)\)s`)
-
 func init() {
 	if runtime.GOOS == "windows" {
 		LineBreak = "\r\n"
@@ -52,34 +51,69 @@ func init() {
 }
 
 // A ConfigFile represents a INI formar configuration file.
+// Readers use the current snapshot of configuration without lock,
+// writers change a copy of it and swap it in atomically,
+// so it is safe to be used by multiple goroutines.
 type ConfigFile struct {
-	lock      sync.RWMutex                 // Go map is not safe.
-	fileNames []string                     // Support mutil-files.
-	data      map[string]map[string]string // Section -> key : value
+	lock    sync.Mutex               // Serializes writers.
+	current atomic.Pointer[snapshot] // Snapshot used by readers.
+
+	BlockMode       bool // Indicates whether writers use lock or not.
+	prettyFormat    bool // Write spaces around "=" to look better.
+	defaultFallback bool // Keys of DEFAULT section are visible in every section.
 
-	// Lists can keep sections and keys in order.
-	sectionList []string            // Section name list.
-	keyList     map[string][]string // Section -> Key name list
+	interpolation InterpolationMode           // Syntax of variables in values.
+	resolver      Resolver                    // Substitutes variables instead of built-in syntax.
+	lookupEnv     func(string) (string, bool) // Looks up environment variables.
+	lenient       bool                        // Allow undefined and cyclic variables.
 
-	sectionComments map[string]string            // Sections comments.
-	keyComments     map[string]map[string]string // Keys comments.
-	BlockMode       bool                         // Indicates whether use lock or not.
-	prettyFormat    bool                         // Write spaces around "=" to look better.
+	timeLayouts []string        // Layouts to parse time values, RFC3339 if empty.
+	boolMode    BoolMode        // Words accepted as bool values.
+	boolWords   map[string]bool // Words registered as bool values in lower case.
 }
 
-// newConfigFile creates an empty configuration representation.
-func newConfigFile(fileNames []string) *ConfigFile {
+// newConfigFile creates a configuration representation of given snapshot.
+func newConfigFile(s *snapshot) *ConfigFile {
 	c := new(ConfigFile)
-	c.fileNames = fileNames
-	c.data = make(map[string]map[string]string)
-	c.keyList = make(map[string][]string)
-	c.sectionComments = make(map[string]string)
-	c.keyComments = make(map[string]map[string]string)
+	c.current.Store(s)
 	c.BlockMode = true
 	c.prettyFormat = true
 	return c
 }
 
+// load returns the current snapshot, which must not be changed.
+func (c *ConfigFile) load() *snapshot {
+	return c.current.Load()
+}
+
+// update changes a copy of current snapshot by fn and makes it current.
+func (c *ConfigFile) update(fn func(s *snapshot)) {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	s := c.load().clone()
+	fn(s)
+	c.current.Store(s)
+}
+
+// swap makes the snapshot returned by fn current unless an error occurs,
+// fn must not change the given snapshot.
+func (c *ConfigFile) swap(fn func(s *snapshot) (*snapshot, error)) error {
+	if c.BlockMode {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+	}
+
+	s, err := fn(c.load())
+	if err != nil {
+		return err
+	}
+	c.current.Store(s)
+	return nil
+}
+
 // SetValue adds a new section-key-value to the configuration.
 // It returns true if the key and value were inserted,
 // or returns false if the value was overwritten.
@@ -93,66 +127,108 @@ func (c *ConfigFile) SetValue(section, key, value string) bool {
 		return false
 	}
 
-	if c.BlockMode {
-		c.lock.Lock()
-		defer c.lock.Unlock()
-	}
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.setValue(section, key, value)
+		s.deleteKeySource(section, key)
+	})
+	return ok
+}
 
-	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
-		// Execute add operation.
-		c.data[section] = make(map[string]string)
-		// Append section to list.
-		c.sectionList = append(c.sectionList, section)
+// DeleteKey deletes the key in given section.
+// It returns true if the key was deleted,
+// or returns false if the section or key didn't exist.
+func (c *ConfigFile) DeleteKey(section, key string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
 	}
 
 	// Check if key exists.
-	_, ok := c.data[section][key]
-	c.data[section][key] = value
-	if !ok {
-		// If not exists, append to key list.
-		c.keyList[section] = append(c.keyList[section], key)
+	if _, ok := c.load().data[section][key]; !ok {
+		return false
 	}
-	return !ok
+
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.deleteKey(section, key)
+	})
+	return ok
 }
 
-// DeleteKey deletes the key in given section.
-// It returns true if the key was deleted,
-// or returns false if the section or key didn't exist.
-func (c *ConfigFile) DeleteKey(section, key string) bool {
+// AppendValue appends a value to the auto increment list of given section,
+// which is written as "- = value" and read back in the same order.
+// It returns the key of the new value, which is "#" followed by its number.
+// If the section does not exist in advance, it will be created.
+func (c *ConfigFile) AppendValue(section, value string) string {
 	// Blank section name represents DEFAULT section.
 	if len(section) == 0 {
 		section = DEFAULT_SECTION
 	}
 
-	if c.BlockMode {
-		c.lock.Lock()
-		defer c.lock.Unlock()
+	var key string
+	c.update(func(s *snapshot) {
+		key = s.nextAutoKey(section)
+		s.setValue(section, key, value)
+	})
+	return key
+}
+
+// GetValues returns values of the auto increment list of given section in order,
+// variables in them are substituted as GetValue does.
+// It returns an error if the section does not exist.
+func (c *ConfigFile) GetValues(section string) ([]string, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
 	}
 
+	s := c.load()
 	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
-		return false
+	if _, ok := s.data[section]; !ok {
+		return nil, GetError{ERR_SECTION_NOT_FOUND, section}
 	}
 
-	// Check if key exists.
-	if _, ok := c.data[section][key]; ok {
-		delete(c.data[section], key)
-		// Remove comments of key.
-		c.SetKeyComments(section, key, "")
-		// Get index of key.
-		i := 0
-		for _, keyName := range c.keyList[section] {
-			if keyName == key {
-				break
-			}
-			i++
+	keys := s.autoKeyList(section)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		value, err := c.getValue(s, section, key)
+		if err != nil {
+			return nil, err
 		}
-		// Remove from key list.
-		c.keyList[section] = append(c.keyList[section][:i], c.keyList[section][i+1:]...)
-		return true
+		values[i] = value
 	}
-	return false
+	return values, nil
+}
+
+// DeleteValueAt deletes the value at given index of the auto increment list
+// of given section. Keys of other values are not changed until it is reloaded.
+// It returns true if the value was deleted,
+// or returns false if the section or index didn't exist.
+func (c *ConfigFile) DeleteValueAt(section string, index int) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	var ok bool
+	c.update(func(s *snapshot) {
+		if keys := s.autoKeyList(section); index >= 0 && index < len(keys) {
+			ok = s.deleteKey(section, keys[index])
+		}
+	})
+	return ok
+}
+
+// SetDefaultFallback sets whether keys of DEFAULT section are visible
+// in every section like Python's configparser does.
+// When it is set, a key is searched in the given section, its parent sections
+// and then DEFAULT section, GetKeyList and GetSection include keys of DEFAULT
+// section, and %(variable)s is searched in the same way instead of DEFAULT
+// section first.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetDefaultFallback(fallback bool) {
+	c.defaultFallback = fallback
 }
 
 // GetValue returns the value of key available in the given section.
@@ -160,72 +236,101 @@ func (c *ConfigFile) DeleteKey(section, key string) bool {
 // (see e.g. %(google)s example in the GoConfig_test.go),
 // then String does this unfolding automatically, up to
 // _DEPTH_VALUES number of iterations.
+// Keys in other sections can be referenced by %(section::key)s,
+// and they are looked up in parent sections too.
+// Environment variables referenced in forms of ${NAME}, ${ENV:NAME}
+// and ${NAME:-default}, and keys referenced by ${section:key}
+// are substituted if SetInterpolation enables the extended syntax.
 // It returns an error and empty string value if the section does not exist,
 // or key does not exist in DEFAULT and current sections.
+// The syntax of variables can be changed by SetInterpolation or SetResolver.
+// It returns InterpolationError if a variable is undefined or references
+// itself, unless lenient interpolation is set.
 func (c *ConfigFile) GetValue(section, key string) (string, error) {
-	if c.BlockMode {
-		c.lock.RLock()
-		defer c.lock.RUnlock()
+	return c.getValue(c.load(), section, key)
+}
+
+// GetRawValue returns the value of key available in the given section
+// as it is in the configuration, without substituting variables.
+// It is useful to edit the configuration without baking expanded values in.
+// It returns an error and empty string value if the section does not exist,
+// or key does not exist in current and parent sections.
+func (c *ConfigFile) GetRawValue(section, key string) (string, error) {
+	value, _, err := c.rawValue(c.load(), section, key)
+	return value, err
+}
+
+// getValue returns the value of key in given snapshot, see GetValue.
+func (c *ConfigFile) getValue(s *snapshot, section, key string) (string, error) {
+	// Values without variables need no resolver.
+	if c.resolver == nil {
+		value, _, err := c.rawValue(s, section, key)
+		if err != nil || (!strings.Contains(value, "%(") && !strings.Contains(value, "${")) {
+			return value, err
+		}
+	}
+	return c.newResolver(s).resolve(section, key)
+}
+
+// rawValue is like getRawValue, but also falls back to DEFAULT section
+// if it is set by SetDefaultFallback. The section is the given one
+// when the key is found in DEFAULT section, so that variables in the value
+// are substituted in the context of given section.
+func (c *ConfigFile) rawValue(s *snapshot, section, key string) (string, string, error) {
+	value, found, err := getRawValue(s, section, key)
+	if err == nil || !c.defaultFallback {
+		return value, found, err
+	}
+
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+	if _, ok := s.data[section]; !ok {
+		return "", section, GetError{ERR_SECTION_NOT_FOUND, section}
 	}
+	if value, ok := s.data[DEFAULT_SECTION][key]; ok {
+		return value, section, nil
+	}
+	return value, found, err
+}
 
+// getRawValue returns the value of key in given snapshot without
+// substituting variables, and the section where the key is found.
+func getRawValue(s *snapshot, section, key string) (string, string, error) {
 	// Blank section name represents DEFAULT section.
 	if len(section) == 0 {
 		section = DEFAULT_SECTION
 	}
 
 	// Check if section exists
-	if _, ok := c.data[section]; !ok {
+	if _, ok := s.data[section]; !ok {
 		// Section does not exist.
-		return "", GetError{ERR_SECTION_NOT_FOUND, section}
+		return "", section, GetError{ERR_SECTION_NOT_FOUND, section}
 	}
 
 	// Section exists.
 	// Check if key exists or empty value.
-	value, ok := c.data[section][key]
+	value, ok := s.data[section][key]
 	if !ok {
 		// Check if it is a sub-section.
 		if i := strings.LastIndex(section, "."); i > -1 {
-			return c.GetValue(section[:i], key)
+			return getRawValue(s, section[:i], key)
 		}
 
 		// Return empty value.
-		return "", GetError{ERR_KEY_NOT_FOUND, key}
+		return "", section, GetError{ERR_KEY_NOT_FOUND, key}
 	}
-
-	// Key exists.
-	var i int
-	for i = 0; i < _DEPTH_VALUES; i++ {
-		vr := varPattern.FindString(value)
-		if len(vr) == 0 {
-			break
-		}
-
-		// Take off leading '%(' and trailing ')s'.
-		noption := strings.TrimLeft(vr, "%(")
-		noption = strings.TrimRight(noption, ")s")
-
-		// Search variable in default section.
-		nvalue, err := c.GetValue(DEFAULT_SECTION, noption)
-		if err != nil && section != DEFAULT_SECTION {
-			// Search in the same section.
-			if _, ok := c.data[section][noption]; ok {
-				nvalue = c.data[section][noption]
-			}
-		}
-
-		// Substitute by new value and take off leading '%(' and trailing ')s'.
-		value = strings.Replace(value, vr, nvalue, -1)
-	}
-	return value, nil
+	return value, section, nil
 }
 
-// Bool returns bool type value.
+// Bool returns bool type value, see SetBoolMode for accepted words.
 func (c *ConfigFile) Bool(section, key string) (bool, error) {
 	value, err := c.GetValue(section, key)
 	if err != nil {
 		return false, err
 	}
-	return strconv.ParseBool(value)
+	return c.parseBool(value)
 }
 
 // Float64 returns float64 type value.
@@ -238,21 +343,84 @@ func (c *ConfigFile) Float64(section, key string) (float64, error) {
 }
 
 // Int returns int type value.
+// Prefixes 0x, 0o and 0b and underscores like 1_000 are accepted,
+// numbers with leading zeros are still decimal.
 func (c *ConfigFile) Int(section, key string) (int, error) {
 	value, err := c.GetValue(section, key)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.Atoi(value)
+	return parseInt(value)
 }
 
-// Int64 returns int64 type value.
+// Int64 returns int64 type value, see Int for the format.
 func (c *ConfigFile) Int64(section, key string) (int64, error) {
 	value, err := c.GetValue(section, key)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.ParseInt(value, 10, 64)
+	return parseInt64(value)
+}
+
+// Uint returns uint type value, see Int for the format.
+func (c *ConfigFile) Uint(section, key string) (uint, error) {
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return parseUint(value)
+}
+
+// Uint64 returns uint64 type value, see Int for the format.
+func (c *ConfigFile) Uint64(section, key string) (uint64, error) {
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return parseUint64(value)
+}
+
+// getInRange returns the value got by get,
+// or RangeError if it is less than min or greater than max.
+func getInRange[T int | int64 | uint | uint64](section, key string, min, max T,
+	get func(section, key string) (T, error)) (T, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	value, err := get(section, key)
+	if err != nil {
+		return 0, err
+	}
+	if value < min || value > max {
+		return 0, RangeError{section, key, fmt.Sprint(value), fmt.Sprint(min), fmt.Sprint(max)}
+	}
+	return value, nil
+}
+
+// IntInRange returns int type value,
+// or RangeError if it is less than min or greater than max.
+func (c *ConfigFile) IntInRange(section, key string, min, max int) (int, error) {
+	return getInRange(section, key, min, max, c.Int)
+}
+
+// Int64InRange returns int64 type value,
+// or RangeError if it is less than min or greater than max.
+func (c *ConfigFile) Int64InRange(section, key string, min, max int64) (int64, error) {
+	return getInRange(section, key, min, max, c.Int64)
+}
+
+// UintInRange returns uint type value,
+// or RangeError if it is less than min or greater than max.
+func (c *ConfigFile) UintInRange(section, key string, min, max uint) (uint, error) {
+	return getInRange(section, key, min, max, c.Uint)
+}
+
+// Uint64InRange returns uint64 type value,
+// or RangeError if it is less than min or greater than max.
+func (c *ConfigFile) Uint64InRange(section, key string, min, max uint64) (uint64, error) {
+	return getInRange(section, key, min, max, c.Uint64)
 }
 
 // MustValue always returns value without error.
@@ -348,11 +516,72 @@ func (c *ConfigFile) MustInt64(section, key string, defaultVal ...int64) int64 {
 	return value
 }
 
+// MustUint always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustUint(section, key string, defaultVal ...uint) uint {
+	value, err := c.Uint(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustUint64 always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustUint64(section, key string, defaultVal ...uint64) uint64 {
+	value, err := c.Uint64(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustIntInRange always returns value without error,
+// it returns 0 if error occurs or the value is out of range.
+func (c *ConfigFile) MustIntInRange(section, key string, min, max int, defaultVal ...int) int {
+	value, err := c.IntInRange(section, key, min, max)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustInt64InRange always returns value without error,
+// it returns 0 if error occurs or the value is out of range.
+func (c *ConfigFile) MustInt64InRange(section, key string, min, max int64, defaultVal ...int64) int64 {
+	value, err := c.Int64InRange(section, key, min, max)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustUintInRange always returns value without error,
+// it returns 0 if error occurs or the value is out of range.
+func (c *ConfigFile) MustUintInRange(section, key string, min, max uint, defaultVal ...uint) uint {
+	value, err := c.UintInRange(section, key, min, max)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustUint64InRange always returns value without error,
+// it returns 0 if error occurs or the value is out of range.
+func (c *ConfigFile) MustUint64InRange(section, key string, min, max uint64, defaultVal ...uint64) uint64 {
+	value, err := c.Uint64InRange(section, key, min, max)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
 // GetSectionList returns the list of all sections
 // in the same order in the file.
 func (c *ConfigFile) GetSectionList() []string {
-	list := make([]string, len(c.sectionList))
-	copy(list, c.sectionList)
+	s := c.load()
+	list := make([]string, len(s.sectionList))
+	copy(list, s.sectionList)
 	return list
 }
 
@@ -365,23 +594,28 @@ func (c *ConfigFile) GetKeyList(section string) []string {
 		section = DEFAULT_SECTION
 	}
 
-	if c.BlockMode {
-		c.lock.RLock()
-		defer c.lock.RUnlock()
-	}
-
+	s := c.load()
 	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
+	if _, ok := s.data[section]; !ok {
 		return nil
 	}
 
 	// Non-default section has a blank key as section keeper.
-	list := make([]string, 0, len(c.keyList[section]))
-	for _, key := range c.keyList[section] {
+	list := make([]string, 0, len(s.keyList[section]))
+	for _, key := range s.keyList[section] {
 		if key != " " {
 			list = append(list, key)
 		}
 	}
+
+	// Keys of DEFAULT section follow the section's own keys.
+	if c.defaultFallback && section != DEFAULT_SECTION {
+		for _, key := range s.keyList[DEFAULT_SECTION] {
+			if _, ok := s.data[section][key]; !ok {
+				list = append(list, key)
+			}
+		}
+	}
 	return list
 }
 
@@ -393,31 +627,16 @@ func (c *ConfigFile) DeleteSection(section string) bool {
 		section = DEFAULT_SECTION
 	}
 
-	if c.BlockMode {
-		c.lock.Lock()
-		defer c.lock.Unlock()
-	}
-
 	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
+	if _, ok := c.load().data[section]; !ok {
 		return false
 	}
 
-	delete(c.data, section)
-	// Remove comments of section.
-	c.SetSectionComments(section, "")
-	// Get index of section.
-	i := 0
-	for _, secName := range c.sectionList {
-		if secName == section {
-			break
-		}
-		i++
-	}
-	// Remove from section and key list.
-	c.sectionList = append(c.sectionList[:i], c.sectionList[i+1:]...)
-	delete(c.keyList, section)
-	return true
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.deleteSection(section)
+	})
+	return ok
 }
 
 // GetSection returns key-value pairs in given section.
@@ -428,21 +647,25 @@ func (c *ConfigFile) GetSection(section string) (map[string]string, error) {
 		section = DEFAULT_SECTION
 	}
 
-	if c.BlockMode {
-		c.lock.Lock()
-		defer c.lock.Unlock()
-	}
-
+	s := c.load()
 	// Check if section exists.
-	if _, ok := c.data[section]; !ok {
+	if _, ok := s.data[section]; !ok {
 		// Section does not exist.
 		return nil, GetError{ERR_SECTION_NOT_FOUND, section}
 	}
 
 	// Remove pre-defined key.
-	secMap := deepCopy(c.data[section])
+	secMap := deepCopy(s.data[section])
 	delete(secMap, " ")
 
+	if c.defaultFallback && section != DEFAULT_SECTION {
+		for key, value := range s.data[DEFAULT_SECTION] {
+			if _, ok := secMap[key]; !ok {
+				secMap[key] = value
+			}
+		}
+	}
+
 	// Section exists.
 	return secMap, nil
 }
@@ -457,22 +680,11 @@ func (c *ConfigFile) SetSectionComments(section, comments string) bool {
 		section = DEFAULT_SECTION
 	}
 
-	if len(comments) == 0 {
-		if _, ok := c.sectionComments[section]; ok {
-			delete(c.sectionComments, section)
-		}
-
-		// Not exists can be seen as remove.
-		return true
-	}
-
-	// Check if comments exists.
-	_, ok := c.sectionComments[section]
-	if comments[0] != '#' && comments[0] != ';' {
-		comments = "; " + comments
-	}
-	c.sectionComments[section] = comments
-	return !ok
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.setSectionComments(section, comments)
+	})
+	return ok
 }
 
 // SetKeyComments adds new section-key comments to the configuration.
@@ -486,33 +698,35 @@ func (c *ConfigFile) SetKeyComments(section, key, comments string) bool {
 		section = DEFAULT_SECTION
 	}
 
-	// Check if section exists.
-	if _, ok := c.keyComments[section]; ok {
-		if len(comments) == 0 {
-			if _, ok := c.keyComments[section][key]; ok {
-				delete(c.keyComments[section], key)
-			}
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.setKeyComments(section, key, comments)
+	})
+	return ok
+}
 
-			// Not exists can be seen as remove.
-			return true
-		}
-	} else {
-		if len(comments) == 0 {
-			// Not exists can be seen as remove.
-			return true
-		} else {
-			// Execute add operation.
-			c.keyComments[section] = make(map[string]string)
-		}
+// SetKeyInlineComment sets the inline comment written after the value
+// of section-key, which must be a single line.
+// If comment is empty(0 length), it will remove the inline comment!
+// It returns true if the comment was inserted or removed,
+// or returns false if the comment was overwritten.
+// It returns false and does nothing if the comment has line breaks,
+// or LoadOptions.InlineComments is not set, because the comment
+// would be read back as part of the value.
+func (c *ConfigFile) SetKeyInlineComment(section, key, comment string) bool {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
 	}
-
-	// Check if key exists.
-	_, ok := c.keyComments[section][key]
-	if comments[0] != '#' && comments[0] != ';' {
-		comments = "; " + comments
+	if !c.load().options.InlineComments || strings.ContainsAny(comment, "\r\n") {
+		return false
 	}
-	c.keyComments[section][key] = comments
-	return !ok
+
+	var ok bool
+	c.update(func(s *snapshot) {
+		ok = s.setInlineComment(section, key, comment)
+	})
+	return ok
 }
 
 // GetSectionComments returns the comments in the given section.
@@ -522,7 +736,7 @@ func (c *ConfigFile) GetSectionComments(section string) (comments string) {
 	if len(section) == 0 {
 		section = DEFAULT_SECTION
 	}
-	return c.sectionComments[section]
+	return c.load().sectionComments[section]
 }
 
 // GetKeyComments returns the comments of key in the given section.
@@ -532,11 +746,18 @@ func (c *ConfigFile) GetKeyComments(section, key string) (comments string) {
 	if len(section) == 0 {
 		section = DEFAULT_SECTION
 	}
+	return c.load().getKeyComments(section, key)
+}
 
-	if _, ok := c.keyComments[section]; ok {
-		return c.keyComments[section][key]
+// GetKeyInlineComment returns the inline comment of key in the given section,
+// which is only read when LoadOptions.InlineComments is set.
+// It returns an empty string(0 length) if the comment does not exist.
+func (c *ConfigFile) GetKeyInlineComment(section, key string) (comment string) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
 	}
-	return ""
+	return c.load().getInlineComment(section, key)
 }
 
 // SetPrettyFormat set the prettyFormat to decide whether write spaces around "=".
@@ -560,3 +781,19 @@ func (err GetError) Error() string {
 	}
 	return "invalid get error"
 }
+
+// RangeError occurs when a value is less than the minimum
+// or greater than the maximum wanted.
+type RangeError struct {
+	Section string
+	Key     string
+	Value   string
+	Min     string
+	Max     string
+}
+
+// Error implements Error interface.
+func (err RangeError) Error() string {
+	return fmt.Sprintf("section '%s' key '%s': value %s out of range [%s, %s]",
+		err.Section, err.Key, err.Value, err.Min, err.Max)
+}
@@ -0,0 +1,132 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"strings"
+)
+
+type lineKind int
+
+const (
+	lineBlank lineKind = iota
+	lineComment
+	lineSection
+	lineKey
+)
+
+// A docLine is a line of the source exactly as it was read.
+type docLine struct {
+	kind    lineKind
+	raw     string // Original bytes including line break.
+	section string // Section the line belongs to.
+
+	// For comment lines.
+	owner *docLine // Section or key line the comment belongs to.
+
+	// For section and key lines.
+	comments string // Comments when the line was read.
+
+	// For key lines.
+	key    string
+	value  string // Value when the line was read.
+	quote  string // Quote around the value.
+	prefix string // Bytes before the value, e.g. "key = ".
+	suffix string // Bytes after the value, e.g. line break.
+	indent string // Indentation of continuation lines, empty if there is none.
+	inline string // Inline comment when the line was read.
+}
+
+// A document is the lossless representation of source,
+// which is used to keep the unchanged lines byte-identical when saving.
+type document struct {
+	bom      bool // Whether the source starts with BOM-UTF8.
+	lines    []*docLine
+	sections map[string]bool // Sections exist in the source.
+}
+
+func newDocument() *document {
+	return &document{sections: make(map[string]bool)}
+}
+
+// lineBreak returns the line break used by the source,
+// or LineBreak if it has no line break.
+func (d *document) lineBreak() string {
+	for _, l := range d.lines {
+		if strings.HasSuffix(l.raw, "\r\n") {
+			return "\r\n"
+		}
+		if strings.HasSuffix(l.raw, "\n") {
+			return "\n"
+		}
+	}
+	return LineBreak
+}
+
+// splitLines splits data into lines and keeps the line breaks.
+func splitLines(data string) []string {
+	lines := make([]string, 0, strings.Count(data, "\n")+1)
+	for len(data) > 0 {
+		i := strings.IndexByte(data, '\n')
+		if i == -1 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// liveLines returns the last key line of each section-key,
+// which is the one takes effect, and the header line that
+// owns the comments of each section.
+func (doc *document) liveLines() (keys map[string]map[string]*docLine, headers map[string]*docLine) {
+	keys = make(map[string]map[string]*docLine)
+	headers = make(map[string]*docLine)
+	for _, l := range doc.lines {
+		switch l.kind {
+		case lineSection:
+			if _, ok := headers[l.section]; !ok || len(l.comments) > 0 {
+				headers[l.section] = l
+			}
+		case lineKey:
+			if _, ok := keys[l.section]; !ok {
+				keys[l.section] = make(map[string]*docLine)
+			}
+			keys[l.section][l.key] = l
+		}
+	}
+	return keys, headers
+}
+
+// anchors returns the line of each section that new keys are written after,
+// which is the last key line, or the last header line if it has no key.
+func (doc *document) anchors() map[string]*docLine {
+	anchors := make(map[string]*docLine)
+	hasKey := make(map[string]bool)
+	for _, l := range doc.lines {
+		switch l.kind {
+		case lineSection:
+			if !hasKey[l.section] {
+				anchors[l.section] = l
+			}
+		case lineKey:
+			anchors[l.section] = l
+			hasKey[l.section] = true
+		}
+	}
+	return anchors
+}
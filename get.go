@@ -0,0 +1,81 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// KeyError occurs when a typed value cannot be got because
+// the section or key does not exist.
+type KeyError struct {
+	Section string
+	Key     string
+	Err     GetError
+}
+
+// Error implements Error interface.
+func (err KeyError) Error() string {
+	return fmt.Sprintf("section '%s' key '%s': %v", err.Section, err.Key, err.Err)
+}
+
+// Unwrap returns the underlying GetError.
+func (err KeyError) Unwrap() error {
+	return err.Err
+}
+
+// keyError returns KeyError if err is GetError,
+// other errors already carry section and key.
+func keyError(section, key string, err error) error {
+	if gerr, ok := err.(GetError); ok {
+		return KeyError{section, key, gerr}
+	}
+	return err
+}
+
+// Get returns the value of key in given section converted to type T,
+// which can be any type supported by MapTo: built-in scalars including
+// complex numbers and uintptr, time.Duration,
+// time.Time, slices split by ",", pointers to them and types implementing
+// encoding.TextUnmarshaler, e.g. net.IP.
+// It returns KeyError if the section or key does not exist,
+// or ValueError if the value cannot be converted.
+func Get[T any](c *ConfigFile, section, key string) (T, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	var v T
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return v, keyError(section, key, err)
+	}
+	if err = c.setWithProperType(reflect.ValueOf(&v).Elem(), value, ""); err != nil {
+		var zero T
+		return zero, ValueError{section, key, value, err}
+	}
+	return v, nil
+}
+
+// GetOr is like Get, but returns the default value if error occurs.
+func GetOr[T any](c *ConfigFile, section, key string, defaultVal T) T {
+	v, err := Get[T](c, section, key)
+	if err != nil {
+		return defaultVal
+	}
+	return v
+}
@@ -16,9 +16,20 @@ package goconfig
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -175,6 +186,104 @@ func TestLoadConfigFile(t *testing.T) {
 	})
 }
 
+func TestPosition(t *testing.T) {
+	Convey("Get positions of sections and keys", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini", "testdata/conf2.ini")
+		So(err, ShouldBeNil)
+		So(c, ShouldNotBeNil)
+
+		pos, ok := c.SectionPosition("Demo")
+		So(ok, ShouldBeTrue)
+		So(pos, ShouldResemble, Position{"testdata/conf.ini", 7, 1})
+
+		pos, ok = c.KeyPosition("Demo", "key1")
+		So(ok, ShouldBeTrue)
+		So(pos.String(), ShouldEqual, "testdata/conf2.ini:9:1")
+
+		pos, ok = c.KeyPosition("Demo", "中国")
+		So(ok, ShouldBeTrue)
+		So(pos.String(), ShouldEqual, "testdata/conf.ini:15:1")
+
+		_, ok = c.KeyPosition("Demo", "key404")
+		So(ok, ShouldBeFalse)
+		c.SetValue("Demo", "key404", "value")
+		_, ok = c.KeyPosition("Demo", "key404")
+		So(ok, ShouldBeFalse)
+
+		So(c.DeleteKey("Demo", "中国"), ShouldBeTrue)
+		_, ok = c.KeyPosition("Demo", "中国")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Get sources of values across files", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini", "testdata/conf2.ini")
+		So(err, ShouldBeNil)
+		So(c, ShouldNotBeNil)
+
+		source, shadowed, ok := c.KeySource("Demo", "key2")
+		So(ok, ShouldBeTrue)
+		So(source, ShouldResemble, ValueSource{
+			Position{"testdata/conf2.ini", 10, 1}, "rewrite this key of conf.ini"})
+		So(shadowed, ShouldResemble, []ValueSource{
+			{Position{"testdata/conf.ini", 10, 1}, "test data"}})
+
+		source, shadowed, ok = c.KeySource("new section", "key1")
+		So(ok, ShouldBeTrue)
+		So(source.File, ShouldEqual, "testdata/conf2.ini")
+		So(shadowed, ShouldBeEmpty)
+
+		_, _, ok = c.KeySource("Demo", "key404")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Forget sources of values set by program", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+
+		c.SetValue("Demo", "key2", "new")
+		_, _, ok := c.KeySource("Demo", "key2")
+		So(ok, ShouldBeFalse)
+		_, ok = c.KeyPosition("Demo", "key2")
+		So(ok, ShouldBeFalse)
+
+		type demo struct {
+			Key1 string `ini:"key1"`
+		}
+		So(c.ReflectFromSection("Demo", demo{"new"}), ShouldBeNil)
+		_, _, ok = c.KeySource("Demo", "key1")
+		So(ok, ShouldBeFalse)
+
+		// Keys not set are still known.
+		_, ok = c.KeyPosition("Demo", "key3")
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("Get sources of inherited values", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+
+		pos, ok := c.KeyPosition("parent.child.child", "name")
+		So(ok, ShouldBeTrue)
+		So(pos.String(), ShouldEqual, "testdata/conf.ini:29:1")
+
+		_, ok = c.KeyPosition("Demo", "google")
+		So(ok, ShouldBeFalse)
+		c.SetDefaultFallback(true)
+		pos, ok = c.KeyPosition("Demo", "google")
+		So(ok, ShouldBeTrue)
+		So(pos.String(), ShouldEqual, "testdata/conf.ini:2:1")
+		_, ok = c.KeyPosition("missing", "google")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Get position of read error", t, func() {
+		_, err := LoadFromReader(bytes.NewBufferString("[Demo]\n  bad line\n"))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "2:3: could not parse line: bad line")
+		So(err.(ReadError).Line, ShouldEqual, 2)
+	})
+}
+
 func TestGetKeyList(t *testing.T) {
 	Convey("Get key list", t, func() {
 		c, err := LoadConfigFile("testdata/conf.ini")
@@ -204,6 +313,143 @@ func TestSaveConfigFile(t *testing.T) {
 
 		So(SaveConfigFile(c, "testdata/conf_test.ini"), ShouldBeNil)
 	})
+
+	Convey("Save a ConfigFile atomically", t, func() {
+		dir, err := ioutil.TempDir("", "goconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("key = old\n"), 0600), ShouldBeNil)
+		link := filepath.Join(dir, "link.ini")
+		So(os.Symlink(name, link), ShouldBeNil)
+
+		c, err := LoadConfigFile(link)
+		So(err, ShouldBeNil)
+		c.SetValue("", "key", "new")
+		So(SaveConfigFile(c, link), ShouldBeNil)
+
+		data, err := ioutil.ReadFile(name)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "key = new\n")
+		fi, err := os.Lstat(link)
+		So(err, ShouldBeNil)
+		So(fi.Mode()&os.ModeSymlink, ShouldNotEqual, 0)
+		fi, err = os.Stat(name)
+		So(err, ShouldBeNil)
+		So(fi.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		// No temporary file is left.
+		infos, err := ioutil.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(len(infos), ShouldEqual, 2)
+
+		So(SaveConfigFile(c, filepath.Join(dir, "404", "app.ini")), ShouldNotBeNil)
+
+		// New file is created with umask applied like os.Create.
+		f, err := os.Create(filepath.Join(dir, "ref.ini"))
+		So(err, ShouldBeNil)
+		ref, err := f.Stat()
+		So(err, ShouldBeNil)
+		f.Close()
+		So(SaveConfigFile(c, filepath.Join(dir, "new.ini")), ShouldBeNil)
+		fi, err = os.Stat(filepath.Join(dir, "new.ini"))
+		So(err, ShouldBeNil)
+		So(fi.Mode().Perm(), ShouldEqual, ref.Mode().Perm())
+	})
+}
+
+func TestSaveConfigFileChecked(t *testing.T) {
+	Convey("Detect changes on disk before saving", t, func() {
+		dir, err := ioutil.TempDir("", "goconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("[app]\nname = app\nport = 80\n"), 0644), ShouldBeNil)
+		c, err := LoadConfigFile(name)
+		So(err, ShouldBeNil)
+
+		Convey("Save when file is unchanged", func() {
+			c.SetValue("app", "port", "8080")
+			So(SaveConfigFileChecked(c, name), ShouldBeNil)
+			c.SetValue("app", "port", "9090")
+			So(SaveConfigFileChecked(c, name), ShouldBeNil)
+		})
+
+		Convey("Save to a new file", func() {
+			So(SaveConfigFileChecked(c, filepath.Join(dir, "new.ini")), ShouldBeNil)
+			So(SaveConfigFileChecked(c, filepath.Join(dir, "new.ini")), ShouldBeNil)
+			So(SaveConfigFileChecked(c, filepath.Join(dir, "app.ini.bak")), ShouldBeNil)
+		})
+
+		Convey("Save by other paths of the same file", func() {
+			wd, err := os.Getwd()
+			So(err, ShouldBeNil)
+			rel, err := filepath.Rel(wd, name)
+			So(err, ShouldBeNil)
+			So(SaveConfigFileChecked(c, "./"+rel), ShouldBeNil)
+			So(SaveConfigFileChecked(c, filepath.Join(dir, ".", "app.ini")), ShouldBeNil)
+			So(SaveConfigFileChecked(c, name), ShouldBeNil)
+		})
+
+		Convey("Fail when file is changed", func() {
+			fi, err := os.Stat(name)
+			So(err, ShouldBeNil)
+			// Make sure modification time changes.
+			So(os.Chtimes(name, fi.ModTime(), fi.ModTime().Add(-time.Hour)), ShouldBeNil)
+			c, err := LoadConfigFile(name)
+			So(err, ShouldBeNil)
+			fi, err = os.Stat(name)
+			So(err, ShouldBeNil)
+
+			So(ioutil.WriteFile(name, []byte("[app]\nname = app\nport = 81\n"), 0644), ShouldBeNil)
+			c.SetValue("app", "port", "8080")
+			err = SaveConfigFileChecked(c, name)
+			So(err, ShouldHaveSameTypeAs, ConflictError{})
+			So(err.(ConflictError).File, ShouldEqual, name)
+			So(err.(ConflictError).ModTime, ShouldEqual, fi.ModTime())
+
+			data, err := ioutil.ReadFile(name)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "[app]\nname = app\nport = 81\n")
+		})
+
+		Convey("Merge changes on disk", func() {
+			So(ioutil.WriteFile(name, []byte("; Edited\n[app]\nname = admin\nport = 80\n"), 0644), ShouldBeNil)
+			c.SetValue("app", "port", "8080")
+			c.SetValue("app", "debug", "true")
+			So(SaveConfigFileMerged(c, name), ShouldBeNil)
+			So(c.MustValue("app", "name"), ShouldEqual, "admin")
+
+			data, err := ioutil.ReadFile(name)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "; Edited\n[app]\nname = admin\nport = 8080\ndebug = true"+LineBreak)
+			So(SaveConfigFileChecked(c, name), ShouldBeNil)
+		})
+
+		Convey("Merge changes after saving to another file", func() {
+			c.SetValue("app", "port", "8080")
+			So(SaveConfigFile(c, filepath.Join(dir, "backup.ini")), ShouldBeNil)
+			So(ioutil.WriteFile(name, []byte("[app]\nname = admin\nport = 80\n"), 0644), ShouldBeNil)
+			So(SaveConfigFileMerged(c, name), ShouldBeNil)
+			So(c.MustValue("app", "port"), ShouldEqual, "8080")
+			So(c.MustValue("app", "name"), ShouldEqual, "admin")
+
+			data, err := ioutil.ReadFile(name)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "[app]\nname = admin\nport = 8080\n")
+		})
+
+		Convey("Fail to merge conflicting changes", func() {
+			So(ioutil.WriteFile(name, []byte("[app]\nname = app\nport = 81\n"), 0644), ShouldBeNil)
+			c.SetValue("app", "port", "8080")
+			err := SaveConfigFileMerged(c, name)
+			So(err, ShouldHaveSameTypeAs, ConflictError{})
+			So(err.(ConflictError).Conflict, ShouldResemble, []Change{{"app", "port"}})
+			So(c.MustValue("app", "port"), ShouldEqual, "8080")
+		})
+	})
 }
 
 func TestSaveConfigData(t *testing.T) {
@@ -219,6 +465,87 @@ func TestSaveConfigData(t *testing.T) {
 	})
 }
 
+func TestSaveLossless(t *testing.T) {
+	Convey("Save a ConfigFile without losing original format", t, func() {
+		Convey("Save unchanged configuration", func() {
+			data, err := ioutil.ReadFile("testdata/conf.ini")
+			So(err, ShouldBeNil)
+			c, err := LoadConfigFile("testdata/conf.ini")
+			So(err, ShouldBeNil)
+
+			var buf bytes.Buffer
+			So(SaveConfigData(c, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(data))
+		})
+
+		data := "; Comments\r\n" +
+			"name:  app  \r\n" +
+			"\r\n" +
+			"[server]\r\n" +
+			"  host   = localhost\r\n" +
+			"port=8080\r\n" +
+			"quoted = `a value`\r\n" +
+			"\r\n" +
+			"; Old section\r\n" +
+			"[old]\r\n" +
+			"key = value\r\n" +
+			"\r\n" +
+			"; Trailing comments"
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		Convey("Only rewrite changed lines", func() {
+			c.SetValue("server", "host", "0.0.0.0")
+			c.SetValue("server", "quoted", "new value")
+			var buf bytes.Buffer
+			So(SaveConfigData(c, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, strings.Replace(strings.Replace(data,
+				"localhost", "0.0.0.0", 1), "a value", "new value", 1))
+		})
+
+		Convey("Add and delete sections and keys", func() {
+			So(c.DeleteSection("old"), ShouldBeTrue)
+			So(c.DeleteKey("server", "port"), ShouldBeTrue)
+			c.SetValue("server", "timeout", "30s")
+			c.SetValue("", "debug", "true")
+			c.SetValue("new", "key", "value")
+			c.SetKeyComments("", "name", "Name of app")
+
+			var buf bytes.Buffer
+			So(SaveConfigData(c, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "; Name of app\r\n"+
+				"name:  app  \r\n"+
+				"debug = true\r\n"+
+				"\r\n"+
+				"[server]\r\n"+
+				"  host   = localhost\r\n"+
+				"quoted = `a value`\r\n"+
+				"timeout = 30s\r\n"+
+				"\r\n"+
+				"\r\n"+
+				"; Trailing comments\r\n"+
+				"\r\n"+
+				"[new]\r\n"+
+				"key = value\r\n"+
+				"\r\n")
+		})
+
+		Convey("Write new lines with line break of the source", func() {
+			c, err := LoadFromReaderWithOptions(LoadOptions{MultiLineValues: true},
+				bytes.NewBufferString("[s]\r\nm = \"\"\"a\r\nb\"\"\"\r\n"))
+			So(err, ShouldBeNil)
+			c.SetValue("s", "m", "c\nd")
+			c.SetValue("s", "n", "x\ny")
+			c.SetKeyComments("s", "n", "; line1\n; line2")
+
+			var buf bytes.Buffer
+			So(SaveConfigData(c, &buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, "[s]\r\nm = \"\"\"c\r\nd\"\"\"\r\n"+
+				"; line1\r\n; line2\r\nn = \"\"\"x\r\ny\"\"\"\r\n")
+		})
+	})
+}
+
 func TestReload(t *testing.T) {
 	Convey("Reload a configuration file", t, func() {
 		c, err := LoadConfigFile("testdata/conf.ini", "testdata/conf2.ini")
@@ -251,6 +578,853 @@ func TestAppendFiles(t *testing.T) {
 	})
 }
 
+func TestWatcher(t *testing.T) {
+	Convey("Watch configuration files and reload", t, func() {
+		dir, err := ioutil.TempDir("", "goconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("[server]\nhost = localhost\nport = 80\n"), 0644), ShouldBeNil)
+		c, err := LoadConfigFile(name)
+		So(err, ShouldBeNil)
+
+		w := c.NewWatcher(10 * time.Millisecond)
+		changed := make(chan []Change, 1)
+		w.OnChange(func(changes []Change) {
+			changed <- changes
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- w.Run(ctx)
+		}()
+
+		// Let watcher records the initial states.
+		time.Sleep(30 * time.Millisecond)
+		So(ioutil.WriteFile(name, []byte("[server]\nhost = 0.0.0.0\n\n[log]\nlevel = info\n"), 0644), ShouldBeNil)
+
+		select {
+		case changes := <-changed:
+			So(changes, ShouldResemble, []Change{{"log", ""}, {"server", "host"}, {"server", "port"}})
+			So(c.MustValue("server", "host"), ShouldEqual, "0.0.0.0")
+		case <-time.After(5 * time.Second):
+			So("timeout", ShouldBeEmpty)
+		}
+
+		cancel()
+		So(<-done, ShouldBeNil)
+	})
+
+	Convey("Cannot watch in-memory data", t, func() {
+		c, err := LoadFromReader(bytes.NewBuffer(nil))
+		So(err, ShouldBeNil)
+		So(c.NewWatcher(time.Second).Run(context.Background()), ShouldNotBeNil)
+	})
+
+	Convey("Cannot watch with non-positive interval", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+		So(c.NewWatcher(0).Run(context.Background()), ShouldNotBeNil)
+		So(c.NewWatcher(-time.Second).Run(context.Background()), ShouldNotBeNil)
+	})
+
+	Convey("Register functions in called functions", t, func() {
+		dir, err := ioutil.TempDir("", "goconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("[server]\nport = 80\n"), 0644), ShouldBeNil)
+		c, err := LoadConfigFile(name)
+		So(err, ShouldBeNil)
+
+		w := c.NewWatcher(time.Second)
+		calls := 0
+		w.OnChange(func(changes []Change) {
+			calls++
+			w.OnChange(func(changes []Change) {})
+		})
+		w.OnError(func(err error) {
+			calls++
+			w.OnError(func(err error) {})
+		})
+		reload := func() bool {
+			done := make(chan bool)
+			go func() {
+				done <- w.reload()
+			}()
+			select {
+			case ok := <-done:
+				return ok
+			case <-time.After(5 * time.Second):
+				return false
+			}
+		}
+
+		So(ioutil.WriteFile(name, []byte("[server]\nport = 8080\n"), 0644), ShouldBeNil)
+		So(reload(), ShouldBeTrue)
+		So(ioutil.WriteFile(name, []byte("[server]\n  bad line\n"), 0644), ShouldBeNil)
+		So(reload(), ShouldBeFalse)
+		So(calls, ShouldEqual, 2)
+		So(w.changeHandles, ShouldHaveLength, 2)
+		So(w.errorHandles, ShouldHaveLength, 2)
+	})
+
+	Convey("Retry reloading after error", t, func() {
+		dir, err := ioutil.TempDir("", "goconfig")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		name := filepath.Join(dir, "app.ini")
+		So(ioutil.WriteFile(name, []byte("[server]\nport = 80\n"), 0644), ShouldBeNil)
+		c, err := LoadConfigFile(name)
+		So(err, ShouldBeNil)
+
+		w := c.NewWatcher(10 * time.Millisecond)
+		failed := make(chan error, 10)
+		w.OnError(func(err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- w.Run(ctx)
+		}()
+
+		time.Sleep(30 * time.Millisecond)
+		So(ioutil.WriteFile(name, []byte("[server]\n  bad line\n"), 0644), ShouldBeNil)
+
+		// The file is not changed again, but reloading is retried.
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-failed:
+				So(err, ShouldNotBeNil)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		}
+		So(c.MustValue("server", "port"), ShouldEqual, "80")
+
+		cancel()
+		So(<-done, ShouldBeNil)
+	})
+}
+
+func TestConcurrency(t *testing.T) {
+	Convey("Read, write, save and reload in multiple goroutines", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini", "testdata/conf2.ini")
+		So(err, ShouldBeNil)
+		So(c, ShouldNotBeNil)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 100)
+		run := func(fn func(i int) error) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if err := fn(i); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+
+		run(func(i int) error {
+			_, err := c.GetValue("Demo", "key3")
+			c.GetSectionList()
+			c.GetKeyList("Demo")
+			c.GetKeyComments("", "google")
+			c.KeyPosition("Demo", "key2")
+			return err
+		})
+		run(func(i int) error {
+			c.SetValue("Demo", fmt.Sprintf("new%d", i), "value")
+			c.SetKeyComments("Demo", "key1", "comments")
+			c.DeleteKey("Demo", fmt.Sprintf("new%d", i-1))
+			return nil
+		})
+		run(func(i int) error {
+			return SaveConfigData(c, ioutil.Discard)
+		})
+		run(func(i int) error {
+			return c.Reload()
+		})
+		run(func(i int) error {
+			var cfg testConfig
+			return c.MapTo(&cfg)
+		})
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+		So(c.MustValue("Demo", "key3"), ShouldNotBeEmpty)
+	})
+
+	Convey("Keep settings after reloading", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+		c.SetPrettyFormat(false)
+		c.BlockMode = false
+
+		So(c.Reload(), ShouldBeNil)
+		So(c.prettyFormat, ShouldBeFalse)
+		So(c.BlockMode, ShouldBeFalse)
+	})
+
+	Convey("Keep old snapshots unchanged by writers", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+		old := c.load()
+
+		c.SetValue("Demo", "key1", "changed")
+		c.SetValue("Demo", "new", "value")
+		c.DeleteKey("Demo", "key2")
+		c.SetKeyComments("Demo", "key3", "comments")
+		c.AppendValue("Demo", "appended")
+		So(c.MustValue("Demo", "key1"), ShouldEqual, "changed")
+		So(c.MustValue("Demo", "key2"), ShouldBeEmpty)
+
+		So(old.data["Demo"]["key1"], ShouldEqual, "Let's us goconfig!!!")
+		So(old.data["Demo"], ShouldNotContainKey, "new")
+		So(old.data["Demo"]["key2"], ShouldEqual, "test data")
+		So(old.keyList["Demo"], ShouldContain, "key2")
+		So(old.keyList["Demo"], ShouldNotContain, "new")
+		So(old.getKeyComments("Demo", "key3"), ShouldBeEmpty)
+		So(old.autoKeyList("Demo"), ShouldBeEmpty)
+		// Sections that are not changed are shared.
+		So(fmt.Sprintf("%p", c.load().data["parent"]), ShouldEqual, fmt.Sprintf("%p", old.data["parent"]))
+	})
+
+	Convey("Keep configuration if reloading fails", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+		So(c.AppendFiles("testdata/conf404.ini"), ShouldNotBeNil)
+		So(c.MustValue("Demo", "key2"), ShouldEqual, "test data")
+		So(c.Reload(), ShouldBeNil)
+	})
+}
+
+func TestEnvInterpolation(t *testing.T) {
+	Convey("Substitute environment variables in values", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(`
+home = /home/${ENV:USER}
+[db]
+host = ${DB_HOST:-localhost}
+port = ${DB_PORT:-5432}
+dsn = postgres://${ENV:USER}@%(host)s:%(port)s
+missing = [${MISSING}]
+other = ${section:key}
+`))
+		So(err, ShouldBeNil)
+
+		So(c.MustValue("", "home"), ShouldEqual, "/home/${ENV:USER}")
+		c.SetInterpolation(INTERPOLATION_ALL)
+		env := map[string]string{"USER": "joe", "DB_HOST": "db.local"}
+		c.SetEnvLookup(func(name string) (string, bool) {
+			val, ok := env[name]
+			return val, ok
+		})
+
+		So(c.MustValue("", "home"), ShouldEqual, "/home/joe")
+		So(c.MustValue("db", "host"), ShouldEqual, "db.local")
+		So(c.MustValue("db", "port"), ShouldEqual, "5432")
+		So(c.MustValue("db", "dsn"), ShouldEqual, "postgres://joe@db.local:5432")
+		_, err = c.GetValue("db", "missing")
+		So(err, ShouldResemble, InterpolationError{Reason: ERR_UNDEFINED_VARIABLE, Section: "db", Key: "missing", Name: "MISSING"})
+		_, err = c.GetValue("db", "other")
+		So(err, ShouldResemble, InterpolationError{Reason: ERR_UNDEFINED_VARIABLE, Section: "db", Key: "other", Name: "section:key"})
+
+		Convey("Replace undefined variables by empty string in lenient mode", func() {
+			c.SetLenientInterpolation(true)
+			So(c.MustValue("db", "missing"), ShouldEqual, "[]")
+			So(c.MustValue("db", "other"), ShouldEqual, "")
+		})
+
+		Convey("Use process environment by default", func() {
+			os.Setenv("GOCONFIG_TEST_USER", "jane")
+			defer os.Unsetenv("GOCONFIG_TEST_USER")
+			c.SetEnvLookup(nil)
+			c.SetValue("", "user", "${GOCONFIG_TEST_USER}")
+			So(c.MustValue("", "user"), ShouldEqual, "jane")
+		})
+	})
+}
+
+func TestCrossSectionInterpolation(t *testing.T) {
+	Convey("Reference keys in other sections", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(`
+scheme = http
+[db]
+host = db.local
+port = 5432
+addr = %(host)s:%(port)s
+[db.replica]
+host = replica.local
+[app]
+db = %(db::addr)s
+url = %(scheme)s://${db:host}:${db:port}
+[worker]
+db = ${db.replica:host}:${db.replica:port}
+replica = %(db.replica::host)s:%(db.replica::port)s
+missing = [%(db::missing)s][${nosection:key}]
+`))
+		So(err, ShouldBeNil)
+		c.SetInterpolation(INTERPOLATION_ALL)
+
+		So(c.MustValue("app", "db"), ShouldEqual, "db.local:5432")
+		So(c.MustValue("app", "url"), ShouldEqual, "http://db.local:5432")
+		So(c.MustValue("worker", "db"), ShouldEqual, "replica.local:5432")
+		So(c.MustValue("worker", "replica"), ShouldEqual, "replica.local:5432")
+		_, err = c.GetValue("worker", "missing")
+		So(err, ShouldResemble, InterpolationError{Reason: ERR_UNDEFINED_VARIABLE, Section: "worker", Key: "missing", Name: "db::missing"})
+
+		c.SetLenientInterpolation(true)
+		So(c.MustValue("worker", "missing"), ShouldEqual, "[][]")
+	})
+}
+
+func TestInterpolationError(t *testing.T) {
+	Convey("Detect cyclic references", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(`
+self = %(self)s
+[a]
+x = %(b::y)s
+[b]
+y = ${c:z}
+[c]
+z = %(a::x)s
+loop = %(loop)s
+ok = %(missing:-x)s
+`))
+		So(err, ShouldBeNil)
+		c.SetInterpolation(INTERPOLATION_ALL)
+
+		_, err = c.GetValue("", "self")
+		So(err, ShouldResemble, InterpolationError{ERR_INTERPOLATION_CYCLE, DEFAULT_SECTION, "self", "DEFAULT::self",
+			[]string{"DEFAULT::self", "DEFAULT::self"}})
+
+		_, err = c.GetValue("a", "x")
+		So(err, ShouldResemble, InterpolationError{ERR_INTERPOLATION_CYCLE, "a", "x", "a::x",
+			[]string{"a::x", "b::y", "c::z", "a::x"}})
+		So(err.Error(), ShouldEqual, "section 'a' key 'x': interpolation cycle: a::x -> b::y -> c::z -> a::x")
+
+		_, err = c.GetValue("c", "loop")
+		So(err, ShouldHaveSameTypeAs, InterpolationError{})
+		So(err.(InterpolationError).Reason, ShouldEqual, ERR_INTERPOLATION_CYCLE)
+
+		_, err = c.GetValue("c", "ok")
+		So(err, ShouldResemble, InterpolationError{Reason: ERR_UNDEFINED_VARIABLE, Section: "c", Key: "ok", Name: "missing:-x"})
+		So(err.Error(), ShouldEqual, "section 'c' key 'ok': undefined variable 'missing:-x'")
+
+		Convey("Report interpolation errors when mapping to struct", func() {
+			var v struct {
+				C struct {
+					Z string `ini:"z"`
+				} `ini:"c"`
+			}
+			err := c.MapTo(&v)
+			So(err, ShouldHaveSameTypeAs, MapError{})
+			So(err.(MapError)[0].Value, ShouldEqual, "%(a::x)s")
+		})
+
+		Convey("Follow cyclic references silently in lenient mode", func() {
+			c.SetLenientInterpolation(true)
+			So(c.MustValue("", "self"), ShouldEqual, "%(self)s")
+			_, err = c.GetValue("a", "x")
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+// upperResolver substitutes variables in form of <section.key> by upper case values.
+type upperResolver struct{}
+
+func (upperResolver) Resolve(section, key, raw string, lookup func(section, key string) (string, error)) (string, error) {
+	if !strings.HasPrefix(raw, "<") || !strings.HasSuffix(raw, ">") {
+		return strings.ToUpper(raw), nil
+	}
+	parts := strings.SplitN(raw[1:len(raw)-1], ".", 2)
+	return lookup(parts[0], parts[1])
+}
+
+func TestInterpolationMode(t *testing.T) {
+	Convey("Change syntax of variables", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(`
+name = joe
+[a]
+python = %(name)s
+extended = ${DEFAULT:name}
+both = %(name)s ${DEFAULT:name}
+ref = <DEFAULT.name>
+self = <a.self>
+pw = abc${x}def
+sh = ${HOME}
+`))
+		So(err, ShouldBeNil)
+
+		// Extended syntax is not enabled by default.
+		So(c.MustValue("a", "both"), ShouldEqual, "joe ${DEFAULT:name}")
+		v, err := c.GetValue("a", "pw")
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, "abc${x}def")
+		So(c.MustValue("a", "sh"), ShouldEqual, "${HOME}")
+
+		c.SetInterpolation(INTERPOLATION_ALL)
+		So(c.MustValue("a", "both"), ShouldEqual, "joe joe")
+
+		c.SetInterpolation(INTERPOLATION_NONE)
+		So(c.MustValue("a", "both"), ShouldEqual, "%(name)s ${DEFAULT:name}")
+
+		c.SetInterpolation(INTERPOLATION_PYTHON)
+		So(c.MustValue("a", "both"), ShouldEqual, "joe ${DEFAULT:name}")
+		So(INTERPOLATION_DEFAULT, ShouldEqual, INTERPOLATION_PYTHON)
+
+		c.SetInterpolation(INTERPOLATION_EXTENDED)
+		So(c.MustValue("a", "both"), ShouldEqual, "%(name)s joe")
+
+		Convey("Use custom resolver", func() {
+			c.SetResolver(upperResolver{})
+			So(c.MustValue("", "name"), ShouldEqual, "JOE")
+			So(c.MustValue("a", "ref"), ShouldEqual, "JOE")
+
+			_, err := c.GetValue("a", "self")
+			So(err, ShouldResemble, InterpolationError{ERR_INTERPOLATION_CYCLE, "a", "self", "a::self",
+				[]string{"a::self", "a::self"}})
+		})
+
+		Convey("Get raw values", func() {
+			v, err := c.GetRawValue("a", "both")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, "%(name)s ${DEFAULT:name}")
+
+			_, err = c.GetRawValue("a", "missing")
+			So(err, ShouldResemble, GetError{ERR_KEY_NOT_FOUND, "missing"})
+		})
+	})
+}
+
+func TestDefaultFallback(t *testing.T) {
+	Convey("Make keys of DEFAULT section visible in every section", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(`
+dir = /usr
+path = %(dir)s/bin
+timeout = 10
+[app]
+dir = /opt/app
+[app.web]
+port = 80
+`))
+		So(err, ShouldBeNil)
+
+		_, err = c.GetValue("app", "timeout")
+		So(err, ShouldResemble, GetError{ERR_KEY_NOT_FOUND, "timeout"})
+		So(c.GetKeyList("app"), ShouldResemble, []string{"dir"})
+
+		c.SetDefaultFallback(true)
+		So(c.MustValue("app", "timeout"), ShouldEqual, "10")
+		So(c.MustValue("app.web", "timeout"), ShouldEqual, "10")
+		So(c.MustValue("app.web", "dir"), ShouldEqual, "/opt/app")
+		So(c.MustValue("", "path"), ShouldEqual, "/usr/bin")
+		So(c.MustValue("app", "path"), ShouldEqual, "/opt/app/bin")
+		So(c.MustValue("app.web", "path"), ShouldEqual, "/opt/app/bin")
+
+		_, err = c.GetValue("app", "missing")
+		So(err, ShouldResemble, GetError{ERR_KEY_NOT_FOUND, "missing"})
+		_, err = c.GetValue("missing", "timeout")
+		So(err, ShouldResemble, GetError{ERR_SECTION_NOT_FOUND, "missing"})
+
+		So(c.GetKeyList("app"), ShouldResemble, []string{"dir", "path", "timeout"})
+		sec, err := c.GetSection("app")
+		So(err, ShouldBeNil)
+		So(sec, ShouldResemble, map[string]string{"dir": "/opt/app", "path": "%(dir)s/bin", "timeout": "10"})
+	})
+}
+
+func TestMultiLine(t *testing.T) {
+	Convey("Read and write triple-quoted values spanning multiple lines", t, func() {
+		data := `[sql]
+query = """
+SELECT *
+  FROM users
+"""
+cert = """-----BEGIN-----
+abc
+-----END-----"""  ; end
+next = value
+`
+		opts := LoadOptions{MultiLineValues: true}
+		c, err := LoadFromReaderWithOptions(opts, bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.MustValue("sql", "query"), ShouldEqual, "\nSELECT *\n  FROM users\n")
+		So(c.MustValue("sql", "cert"), ShouldEqual, "-----BEGIN-----\nabc\n-----END-----")
+		So(c.MustValue("sql", "next"), ShouldEqual, "value")
+
+		pos, ok := c.KeyPosition("sql", "next")
+		So(ok, ShouldBeTrue)
+		So(pos.Line, ShouldEqual, 9)
+
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, data)
+
+		c.SetValue("sql", "cert", "new\ncert")
+		c.SetValue("sql", "new", "a\nb")
+		buf.Reset()
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `[sql]
+query = """
+SELECT *
+  FROM users
+"""
+cert = """new
+cert"""  ; end
+next = value
+new = """a
+b"""
+`)
+
+		c2, err := LoadFromReaderWithOptions(opts, &buf)
+		So(err, ShouldBeNil)
+		So(c2.MustValue("sql", "cert"), ShouldEqual, "new\ncert")
+		So(c2.MustValue("sql", "new"), ShouldEqual, "a\nb")
+
+		_, err = LoadFromReaderWithOptions(opts, bytes.NewBufferString("[sql]\nquery = \"\"\"\nSELECT\n"))
+		So(err, ShouldResemble, ReadError{ERR_COULD_NOT_PARSE, `query = """`, Position{"", 2, 1}})
+	})
+
+	Convey("Read short triple quotes as they are by default", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString("[s]\nk = \"\"\"x\nj = 1\nm = \"\"\"\n"))
+		So(err, ShouldBeNil)
+		So(c.MustValue("s", "k"), ShouldEqual, `"""x`)
+		So(c.MustValue("s", "j"), ShouldEqual, "1")
+		So(c.MustValue("s", "m"), ShouldEqual, `"""`)
+
+		c, err = LoadFromReader(bytes.NewBufferString("[s]\nk = \"\"\"\n"))
+		So(err, ShouldBeNil)
+		So(c.MustValue("s", "k"), ShouldEqual, `"""`)
+
+		// Multi-line value cannot be written without the option.
+		c.SetValue("s", "k", "a\nb")
+		So(SaveConfigData(c, ioutil.Discard), ShouldNotBeNil)
+	})
+
+	Convey("Read and write continuation lines", t, func() {
+		data := `[sql]
+query =
+    SELECT *
+    FROM users
+list = a
+  b
+; comment
+  key = value
+`
+		c, err := LoadFromReader(bytes.NewBufferString("[sql]\nlist = a\n  key = value\n"))
+		So(err, ShouldBeNil)
+		So(c.MustValue("sql", "list"), ShouldEqual, "a")
+		So(c.MustValue("sql", "key"), ShouldEqual, "value")
+
+		c, err = LoadFromReaderWithOptions(LoadOptions{ContinuationLines: true, MultiLineValues: true}, bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.MustValue("sql", "query"), ShouldEqual, "SELECT *\nFROM users")
+		So(c.MustValue("sql", "list"), ShouldEqual, "a\nb")
+		So(c.MustValue("sql", "key"), ShouldEqual, "value")
+
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, data)
+
+		c.SetValue("sql", "query", "SELECT id\nFROM users\nWHERE id = 1")
+		c.SetValue("sql", "list", "a\n  b")
+		buf.Reset()
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `[sql]
+query =
+    SELECT id
+    FROM users
+    WHERE id = 1
+list = """a
+  b"""
+; comment
+  key = value
+`)
+
+		So(c.ReloadData(bytes.NewBufferString(buf.String())), ShouldBeNil)
+		So(c.MustValue("sql", "query"), ShouldEqual, "SELECT id\nFROM users\nWHERE id = 1")
+		So(c.MustValue("sql", "list"), ShouldEqual, "a\n  b")
+	})
+}
+
+func TestInlineComments(t *testing.T) {
+	Convey("Read and write inline comments", t, func() {
+		data := `[server]
+host = localhost ; where to listen
+port = 8080  # default port
+url = http://example.com/#anchor
+quoted = ` + "`a ; b` ; quoted" + `
+double = "a # b" # double
+plain = value
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.MustValue("server", "host"), ShouldEqual, "localhost ; where to listen")
+		So(c.GetKeyInlineComment("server", "host"), ShouldBeEmpty)
+
+		c, err = LoadFromReaderWithOptions(LoadOptions{InlineComments: true}, bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.MustValue("server", "host"), ShouldEqual, "localhost")
+		So(c.GetKeyInlineComment("server", "host"), ShouldEqual, "; where to listen")
+		So(c.MustValue("server", "port"), ShouldEqual, "8080")
+		So(c.GetKeyInlineComment("server", "port"), ShouldEqual, "# default port")
+		So(c.MustValue("server", "url"), ShouldEqual, "http://example.com/#anchor")
+		So(c.GetKeyInlineComment("server", "url"), ShouldBeEmpty)
+		So(c.MustValue("server", "quoted"), ShouldEqual, "a ; b")
+		So(c.GetKeyInlineComment("server", "quoted"), ShouldEqual, "; quoted")
+		So(c.MustValue("server", "double"), ShouldEqual, `"a # b"`)
+		So(c.GetKeyInlineComment("server", "double"), ShouldEqual, "# double")
+
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, data)
+
+		c.SetValue("server", "host", "0.0.0.0")
+		So(c.SetKeyInlineComment("server", "port", "changed"), ShouldBeFalse)
+		So(c.SetKeyInlineComment("server", "quoted", ""), ShouldBeTrue)
+		So(c.SetKeyInlineComment("server", "plain", "# new"), ShouldBeTrue)
+		c.SetValue("server", "new", "value")
+		c.SetKeyInlineComment("server", "new", "added")
+		buf.Reset()
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `[server]
+host = 0.0.0.0 ; where to listen
+port = 8080  ; changed
+url = http://example.com/#anchor
+quoted = `+"`a ; b`"+`
+double = "a # b" # double
+plain = value # new
+new = value ; added
+`)
+	})
+
+	Convey("Refuse inline comments that cannot be read back", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString("[s]\nk = v\n"))
+		So(err, ShouldBeNil)
+		So(c.SetKeyInlineComment("s", "k", "note"), ShouldBeFalse)
+		So(c.GetKeyInlineComment("s", "k"), ShouldBeEmpty)
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "[s]\nk = v\n")
+
+		c, err = LoadFromReaderWithOptions(LoadOptions{InlineComments: true}, bytes.NewBufferString("[s]\nk = v\n"))
+		So(err, ShouldBeNil)
+		So(c.SetKeyInlineComment("s", "k", "note\nx = y"), ShouldBeFalse)
+		So(c.SetKeyInlineComment("s", "k", "note\r"), ShouldBeFalse)
+		So(c.GetKeyInlineComment("s", "k"), ShouldBeEmpty)
+		So(c.SetKeyInlineComment("s", "k", "note"), ShouldBeTrue)
+		So(c.GetKeyInlineComment("s", "k"), ShouldEqual, "; note")
+	})
+}
+
+func TestEscapeSequences(t *testing.T) {
+	Convey("Read and write values with escape sequences", t, func() {
+		data := `[escape]
+newline = "line1\nline2"
+tab = "a\tb"
+space = "  padded  "
+quote = "say \"hi\"" ; comment
+unicode = "\u4e2d\u6587"
+plain = "not closed
+`
+		opts := LoadOptions{EscapeSequences: true, InlineComments: true}
+		_, err := LoadFromReaderWithOptions(opts, bytes.NewBufferString(data))
+		So(err, ShouldResemble, ReadError{ERR_COULD_NOT_PARSE, `plain = "not closed`, Position{"", 7, 1}})
+
+		data = strings.Replace(data, `plain = "not closed`, `plain = value`, 1)
+		c, err := LoadFromReaderWithOptions(opts, bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.MustValue("escape", "newline"), ShouldEqual, "line1\nline2")
+		So(c.MustValue("escape", "tab"), ShouldEqual, "a\tb")
+		So(c.MustValue("escape", "space"), ShouldEqual, "  padded  ")
+		So(c.MustValue("escape", "quote"), ShouldEqual, `say "hi"`)
+		So(c.GetKeyInlineComment("escape", "quote"), ShouldEqual, "; comment")
+		So(c.MustValue("escape", "unicode"), ShouldEqual, "中文")
+
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, data)
+
+		c.SetValue("escape", "quote", `\"`)
+		c.SetValue("escape", "plain", " \r\n")
+		c.SetValue("escape", "new", "a\tb")
+		c.SetValue("escape", "normal", "value")
+		buf.Reset()
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, `[escape]
+newline = "line1\nline2"
+tab = "a\tb"
+space = "  padded  "
+quote = "\\\"" ; comment
+unicode = "\u4e2d\u6587"
+plain = " \r\n"
+new = "a\tb"
+normal = value
+`)
+
+		c2, err := LoadFromReaderWithOptions(opts, &buf)
+		So(err, ShouldBeNil)
+		So(c2.MustValue("escape", "quote"), ShouldEqual, `\"`)
+		So(c2.MustValue("escape", "plain"), ShouldEqual, " \r\n")
+		So(c2.MustValue("escape", "new"), ShouldEqual, "a\tb")
+
+		Convey("Keep double quotes without escape mode", func() {
+			c, err := LoadFromReader(bytes.NewBufferString(data))
+			So(err, ShouldBeNil)
+			So(c.MustValue("escape", "tab"), ShouldEqual, `"a\tb"`)
+		})
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	Convey("Keys and values are read back as they are written", t, func() {
+		rnd := rand.New(rand.NewSource(1))
+		chars := []rune("ab =:;#`\"[]-\\\t\r\n中")
+		randString := func(n int, chars []rune) string {
+			s := make([]rune, n)
+			for i := range s {
+				s[i] = chars[rnd.Intn(len(chars))]
+			}
+			return string(s)
+		}
+		// Key cannot have line break.
+		keyChars := chars[:len(chars)-2]
+
+		for _, opts := range []LoadOptions{
+			{},
+			{InlineComments: true},
+			{MultiLineValues: true},
+			{MultiLineValues: true, InlineComments: true},
+			{EscapeSequences: true},
+			{EscapeSequences: true, InlineComments: true},
+		} {
+			saved := 0
+			for i := 0; i < 500; i++ {
+				c, err := LoadFromReaderWithOptions(opts, bytes.NewBufferString(""))
+				So(err, ShouldBeNil)
+				want := make(map[string]string)
+				for j := 0; j < 5; j++ {
+					key := randString(1+rnd.Intn(6), keyChars)
+					if key == " " {
+						// Section keeper.
+						continue
+					}
+					want[key] = randString(rnd.Intn(8), chars)
+					c.SetValue("section", key, want[key])
+				}
+
+				var buf bytes.Buffer
+				if err = SaveConfigData(c, &buf); err != nil {
+					So(opts.EscapeSequences, ShouldBeFalse)
+					continue
+				}
+				saved++
+
+				data := buf.String()
+				c, err = LoadFromReaderWithOptions(opts, &buf)
+				So(err, ShouldBeNil)
+				got, err := c.GetSection("section")
+				So(err, ShouldBeNil)
+				if len(want) == 0 {
+					want = map[string]string{}
+				}
+				So(got, ShouldResemble, want)
+				if t.Failed() {
+					t.Logf("options %+v:\n%s", opts, data)
+					return
+				}
+			}
+			if opts.MultiLineValues || opts.EscapeSequences {
+				So(saved, ShouldBeGreaterThan, 400)
+			} else {
+				// Multi-line values cannot be written.
+				So(saved, ShouldBeGreaterThan, 100)
+			}
+		}
+	})
+
+	Convey("Return error if value cannot be written", t, func() {
+		c, err := LoadFromReader(bytes.NewBufferString(""))
+		So(err, ShouldBeNil)
+		c.SetValue("section", "key", "a\n\"\"\"")
+		So(SaveConfigData(c, ioutil.Discard).Error(), ShouldEqual,
+			"section 'section' key 'key': value cannot be written without escape sequences")
+
+		c.SetValue("section", "key", "  a`\"\"\"b  ")
+		c.SetValue("section", "-", "-")
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "[section]\nkey = `  a`\"\"\"b  `\n`-` = -\n\n")
+	})
+}
+
+func TestAutoIncrement(t *testing.T) {
+	Convey("Continue auto increment across files", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini", "testdata/conf2.ini")
+		So(err, ShouldBeNil)
+
+		values, err := c.GetValues("auto increment")
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"hello", "go", "config", "hello", "go", "config"})
+		So(c.MustValue("auto increment", "#6"), ShouldEqual, "config")
+
+		_, err = c.GetValues("missing")
+		So(err, ShouldResemble, GetError{ERR_SECTION_NOT_FOUND, "missing"})
+	})
+
+	Convey("Manipulate auto increment list", t, func() {
+		data := "[list]\n- = a\n\"#tag\" = tag\n- = b\n[list]\n- = c\n"
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+		So(c.GetKeyList("list"), ShouldResemble, []string{"#1", "#tag", "#2", "#3"})
+		values, err := c.GetValues("list")
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"a", "b", "c"})
+
+		var buf bytes.Buffer
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, data)
+
+		So(c.AppendValue("list", "d"), ShouldEqual, "#4")
+		So(c.DeleteValueAt("list", 1), ShouldBeTrue)
+		So(c.DeleteValueAt("list", 3), ShouldBeFalse)
+		So(c.DeleteValueAt("missing", 0), ShouldBeFalse)
+		c.SetValue("list", "#new", "literal")
+		So(c.AppendValue("", "default"), ShouldEqual, "#1")
+
+		values, err = c.GetValues("list")
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"a", "c", "d"})
+
+		buf.Reset()
+		So(SaveConfigData(c, &buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, "- = default\n\n[list]\n- = a\n\"#tag\" = tag\n[list]\n- = c\n- = d\n`#new` = literal\n")
+
+		c, err = LoadFromReader(&buf)
+		So(err, ShouldBeNil)
+		values, err = c.GetValues("list")
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"a", "c", "d"})
+		So(c.MustValue("list", "#tag"), ShouldEqual, "tag")
+		So(c.MustValue("list", "#new"), ShouldEqual, "literal")
+	})
+}
+
 func TestTypes(t *testing.T) {
 	Convey("Return with types", t, func() {
 		c, err := LoadConfigFile("testdata/conf.ini")
@@ -366,6 +1540,470 @@ func TestArray(t *testing.T) {
 	})
 }
 
+// testLevel is an enum converted from and to text.
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level '%s'", text)
+	}
+	return nil
+}
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"debug", "info"}[l]), nil
+}
+
+func TestGet(t *testing.T) {
+	Convey("Get values with generic type", t, func() {
+		data := `name = goconfig
+port = 0x1F90
+ratio = 0.5
+debug = true
+timeout = 1m
+since = 2024-02-03T04:05:06Z
+ip = 127.0.0.1
+level = info
+bad_level = trace
+ports = 80, 443
+complex = 1+2i
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		name, err := Get[string](c, "", "name")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, "goconfig")
+		port, err := Get[uint16](c, "", "port")
+		So(err, ShouldBeNil)
+		So(port, ShouldEqual, 8080)
+		So(GetOr(c, "", "ratio", float32(1)), ShouldEqual, 0.5)
+		So(GetOr(c, "", "debug", false), ShouldBeTrue)
+		So(GetOr(c, "", "timeout", time.Second), ShouldEqual, time.Minute)
+		So(GetOr(c, "", "since", time.Time{}), ShouldEqual, time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC))
+		So(GetOr(c, "", "ip", net.IP(nil)).Equal(net.IPv4(127, 0, 0, 1)), ShouldBeTrue)
+		So(GetOr(c, "", "level", testLevel(0)), ShouldEqual, testLevel(1))
+		So(GetOr(c, "", "ports", []int(nil)), ShouldResemble, []int{80, 443})
+		p, err := Get[*int](c, "", "port")
+		So(err, ShouldBeNil)
+		So(*p, ShouldEqual, 8080)
+
+		_, err = Get[int](c, "", "missing")
+		So(err, ShouldResemble, KeyError{DEFAULT_SECTION, "missing", GetError{ERR_KEY_NOT_FOUND, "missing"}})
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'missing': key 'missing' not found")
+		var gerr GetError
+		So(errors.As(err, &gerr), ShouldBeTrue)
+		_, err = Get[int](c, "missing", "port")
+		So(err, ShouldResemble, KeyError{"missing", "port", GetError{ERR_SECTION_NOT_FOUND, "missing"}})
+		So(GetOr(c, "", "missing", 3), ShouldEqual, 3)
+
+		So(GetOr(c, "", "port", uintptr(0)), ShouldEqual, uintptr(8080))
+		So(GetOr(c, "", "ratio", complex64(0)), ShouldEqual, complex64(0.5))
+		So(GetOr(c, "", "complex", complex128(0)), ShouldEqual, complex(1, 2))
+
+		_, err = Get[int8](c, "", "port")
+		verr, ok := err.(ValueError)
+		So(ok, ShouldBeTrue)
+		So(verr.Section, ShouldEqual, DEFAULT_SECTION)
+		So(verr.Key, ShouldEqual, "port")
+		_, err = Get[testLevel](c, "", "bad_level")
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'bad_level': cannot convert value 'trace': unknown level 'trace'")
+		So(GetOr(c, "", "bad_level", testLevel(1)), ShouldEqual, testLevel(1))
+		_, err = Get[map[string]int](c, "", "name")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Map and reflect text types", t, func() {
+		type server struct {
+			IP    net.IP    `ini:"ip"`
+			Level testLevel `ini:"level"`
+			Since time.Time `ini:"since"`
+		}
+		c, err := LoadFromData([]byte(""))
+		So(err, ShouldBeNil)
+		since := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+		So(c.ReflectFrom(&server{net.IPv4(10, 0, 0, 1), 1, since}), ShouldBeNil)
+		So(c.MustValue("", "ip"), ShouldEqual, "10.0.0.1")
+		So(c.MustValue("", "level"), ShouldEqual, "info")
+		So(c.MustValue("", "since"), ShouldEqual, "2024-02-03T04:05:06Z")
+
+		s := new(server)
+		So(c.MapTo(s), ShouldBeNil)
+		So(s.IP.Equal(net.IPv4(10, 0, 0, 1)), ShouldBeTrue)
+		So(s.Level, ShouldEqual, testLevel(1))
+		So(s.Since, ShouldEqual, since)
+	})
+}
+
+func TestBoolWords(t *testing.T) {
+	Convey("Return bool with extended words", t, func() {
+		data := "yes = Yes\nno = NO\non = on\noff = Off\nenabled = Enabled\ndisabled = disabled\n" +
+			"true = TRUE\nsi = si\nnein = Nein\nbad = maybe\n"
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		_, err = c.Bool("", "yes")
+		So(err, ShouldNotBeNil)
+		So(c.MustBool("", "true"), ShouldBeTrue)
+
+		c.SetBoolMode(BOOL_EXTENDED)
+		for key, want := range map[string]bool{
+			"yes": true, "no": false, "on": true, "off": false,
+			"enabled": true, "disabled": false, "true": true,
+		} {
+			v, err := c.Bool("", key)
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, want)
+		}
+		_, err = c.Bool("", "bad")
+		So(err, ShouldNotBeNil)
+		So(c.MustBool("", "bad", true), ShouldBeTrue)
+		So(c.MustBools("", "yes", ",", false), ShouldResemble, []bool{true})
+
+		c.SetBoolMode(BOOL_STRICT)
+		c.RegisterBoolWords(true, "SI")
+		c.RegisterBoolWords(false, "nein")
+		So(c.MustBool("", "si"), ShouldBeTrue)
+		So(c.MustBool("", "nein", true), ShouldBeFalse)
+		So(c.MustBool("", "yes", true), ShouldBeTrue)
+
+		cfg := new(struct {
+			Si   bool `ini:"si"`
+			Nein bool `ini:"nein"`
+			On   bool `ini:"on"`
+		})
+		So(c.MapTo(cfg), ShouldNotBeNil)
+		c.SetBoolMode(BOOL_EXTENDED)
+		So(c.MapTo(cfg), ShouldBeNil)
+		So(cfg.Si, ShouldBeTrue)
+		So(cfg.Nein, ShouldBeFalse)
+		So(cfg.On, ShouldBeTrue)
+	})
+}
+
+func TestIntegers(t *testing.T) {
+	Convey("Return integers in different bases", t, func() {
+		data := `hex = 0x1F
+mode = 0o755
+flags = 0b101
+million = 1_000_000
+zero = 0755
+zero_underscore = 0_755
+bad_underscore = 1__000
+negative = -0x10
+positive = +5
+double_plus = ++5
+port = 8080
+big = 18446744073709551615
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		So(c.MustInt("", "hex"), ShouldEqual, 31)
+		So(c.MustInt64("", "mode"), ShouldEqual, 0755)
+		So(c.MustInt("", "flags"), ShouldEqual, 5)
+		So(c.MustInt("", "million"), ShouldEqual, 1000000)
+		So(c.MustInt("", "zero"), ShouldEqual, 755)
+		So(c.MustInt("", "zero_underscore"), ShouldEqual, 755)
+		So(c.MustUint64("", "zero_underscore"), ShouldEqual, 755)
+		_, err = c.Int("", "bad_underscore")
+		So(err, ShouldNotBeNil)
+		So(c.MustInt64("", "negative"), ShouldEqual, -16)
+		So(c.MustInts("", "hex", ","), ShouldResemble, []int{31})
+
+		u, err := c.Uint64("", "big")
+		So(err, ShouldBeNil)
+		So(u, ShouldEqual, uint64(math.MaxUint64))
+		So(c.MustUint("", "mode"), ShouldEqual, 0755)
+		_, err = c.Uint("", "negative")
+		So(err, ShouldNotBeNil)
+		So(c.MustUint("", "negative", 1), ShouldEqual, 1)
+		So(c.MustUint64("", "negative"), ShouldEqual, 0)
+		So(c.MustInt("", "positive"), ShouldEqual, 5)
+		So(c.MustUint("", "positive"), ShouldEqual, 5)
+		So(c.MustUint64("", "positive"), ShouldEqual, 5)
+		_, err = c.Uint("", "double_plus")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, `strconv.ParseUint: parsing "++5": invalid syntax`)
+		_, err = c.Int64("", "big")
+		So(err, ShouldNotBeNil)
+
+		cfg := new(struct {
+			Mode  os.FileMode `ini:"mode"`
+			Hex   uint8       `ini:"hex"`
+			Zero  int         `ini:"zero"`
+			Flags []int64     `ini:"flags"`
+		})
+		So(c.MapTo(cfg), ShouldBeNil)
+		So(cfg.Mode, ShouldEqual, os.FileMode(0755))
+		So(cfg.Hex, ShouldEqual, 31)
+		So(cfg.Zero, ShouldEqual, 755)
+		So(cfg.Flags, ShouldResemble, []int64{5})
+	})
+
+	Convey("Return integers in range", t, func() {
+		c, err := LoadFromData([]byte("port = 8080\nbad = x\n"))
+		So(err, ShouldBeNil)
+
+		port, err := c.IntInRange("", "port", 1, 65535)
+		So(err, ShouldBeNil)
+		So(port, ShouldEqual, 8080)
+		_, err = c.IntInRange("", "port", 1, 1024)
+		So(err, ShouldResemble, RangeError{DEFAULT_SECTION, "port", "8080", "1", "1024"})
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'port': value 8080 out of range [1, 1024]")
+		_, err = c.Int64InRange("", "bad", 1, 1024)
+		So(err, ShouldNotBeNil)
+		_, ok := err.(RangeError)
+		So(ok, ShouldBeFalse)
+
+		So(c.MustInt64InRange("", "port", 8080, 8080), ShouldEqual, 8080)
+		So(c.MustUintInRange("", "port", 1, 1024), ShouldEqual, 0)
+		So(c.MustUintInRange("", "port", 1, 1024, 80), ShouldEqual, 80)
+		So(c.MustUint64InRange("", "port", 9000, 9999, 9000), ShouldEqual, 9000)
+		So(c.MustIntInRange("", "missing", 1, 2, 2), ShouldEqual, 2)
+	})
+}
+
+func TestTypedList(t *testing.T) {
+	Convey("Return typed lists", t, func() {
+		data := `ints = 1, 2 ,3
+floats = 1.5;2
+bools = true,false
+durations = 1s, 2m
+bad = 1,two,3
+quoted = a, "b, c", "say \"hi\"" ,d
+unclosed = a,"b
+empty =
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		ints, err := c.Ints("", "ints", "")
+		So(err, ShouldBeNil)
+		So(ints, ShouldResemble, []int{1, 2, 3})
+		int64s, err := c.Int64s("", "ints", ",")
+		So(err, ShouldBeNil)
+		So(int64s, ShouldResemble, []int64{1, 2, 3})
+		floats, err := c.Float64s("", "floats", ";")
+		So(err, ShouldBeNil)
+		So(floats, ShouldResemble, []float64{1.5, 2})
+		bools, err := c.Bools("", "bools", ",")
+		So(err, ShouldBeNil)
+		So(bools, ShouldResemble, []bool{true, false})
+		durations, err := c.Durations("", "durations", ",")
+		So(err, ShouldBeNil)
+		So(durations, ShouldResemble, []time.Duration{time.Second, 2 * time.Minute})
+		strs, err := c.Strings("", "quoted", ",")
+		So(err, ShouldBeNil)
+		So(strs, ShouldResemble, []string{"a", "b, c", `say "hi"`, "d"})
+		strs, err = c.Strings("", "empty", ",")
+		So(err, ShouldBeNil)
+		So(strs, ShouldResemble, []string{})
+
+		_, err = c.Ints("", "bad", ",")
+		lerr, ok := err.(ListError)
+		So(ok, ShouldBeTrue)
+		So(lerr.Index, ShouldEqual, 1)
+		So(lerr.Value, ShouldEqual, "two")
+		So(err.Error(), ShouldStartWith, "section 'DEFAULT' key 'bad': element 1 'two': ")
+		_, err = c.Strings("", "unclosed", ",")
+		So(err, ShouldResemble, ListError{DEFAULT_SECTION, "unclosed", -1, `a,"b`, err.(ListError).Err})
+		_, err = c.Ints("", "missing", ",")
+		So(err, ShouldResemble, KeyError{DEFAULT_SECTION, "missing", GetError{ERR_KEY_NOT_FOUND, "missing"}})
+
+		So(c.MustInts("", "ints", ","), ShouldResemble, []int{1, 2, 3})
+		So(c.MustInts("", "bad", ","), ShouldResemble, []int{})
+		So(c.MustInts("", "bad", ",", 4, 5), ShouldResemble, []int{4, 5})
+		So(c.MustBools("", "missing", ",", true), ShouldResemble, []bool{true})
+		So(c.MustStrings("", "quoted", ","), ShouldHaveLength, 4)
+	})
+
+	Convey("Set typed lists", t, func() {
+		c, err := LoadFromData([]byte(""))
+		So(err, ShouldBeNil)
+
+		So(c.SetInts("", "ints", ",", 1, 2, 3), ShouldBeTrue)
+		So(c.MustValue("", "ints"), ShouldEqual, "1,2,3")
+		c.SetFloat64s("", "floats", ";", 1.5, 2)
+		So(c.MustValue("", "floats"), ShouldEqual, "1.5;2")
+		c.SetBools("", "bools", ",", true, false)
+		So(c.MustValue("", "bools"), ShouldEqual, "true,false")
+		c.SetDurations("", "durations", ",", time.Second, 90*time.Minute)
+		So(c.MustValue("", "durations"), ShouldEqual, "1s,1h30m0s")
+		c.SetInt64s("", "int64s", " ", 1, -2)
+		So(c.MustInt64s("", "int64s", " "), ShouldResemble, []int64{1, -2})
+
+		strs := []string{"a", "b, c", `"quoted"`, ` \ `, ""}
+		c.SetStrings("", "strs", ",", strs...)
+		So(c.MustValue("", "strs"), ShouldEqual, `a,"b, c","\"quoted\""," \\ ",""`)
+		So(c.MustStrings("", "strs", ","), ShouldResemble, strs)
+		c.SetStrings("", "strs", " ", "x y", "z")
+		So(c.MustStrings("", "strs", " "), ShouldResemble, []string{"x y", "z"})
+	})
+}
+
+func TestUnits(t *testing.T) {
+	Convey("Return duration, byte size and time", t, func() {
+		data := `timeout = 30s
+max_body = 10MB
+cache = 1.5 GiB
+small = 512
+bad_size = 10XB
+huge = 9EiB
+date = 2024-02-03T04:05:06Z
+day = 2024-02-03
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		d, err := c.Duration("", "timeout")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 30*time.Second)
+		_, err = c.Duration("", "max_body")
+		So(err, ShouldNotBeNil)
+		So(c.MustDuration("", "max_body"), ShouldEqual, 0)
+		So(c.MustDuration("", "max_body", time.Minute), ShouldEqual, time.Minute)
+
+		size, err := c.ByteSize("", "max_body")
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 10000000)
+		So(c.MustByteSize("", "cache"), ShouldEqual, 3<<29)
+		So(c.MustByteSize("", "small"), ShouldEqual, 512)
+		_, err = c.ByteSize("", "bad_size")
+		So(err.Error(), ShouldEqual, "invalid byte size '10XB'")
+		_, err = c.ByteSize("", "huge")
+		So(err.Error(), ShouldEqual, "byte size '9EiB' out of range")
+		So(c.MustByteSize("", "bad_size", 1024), ShouldEqual, 1024)
+
+		date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+		tm, err := c.Time("", "date")
+		So(err, ShouldBeNil)
+		So(tm.Equal(date), ShouldBeTrue)
+		_, err = c.Time("", "day")
+		So(err, ShouldNotBeNil)
+		So(c.MustTime("", "day", date), ShouldEqual, date)
+
+		c.SetTimeLayouts(time.RFC3339, "2006-01-02")
+		tm, err = c.Time("", "day")
+		So(err, ShouldBeNil)
+		So(tm, ShouldEqual, time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC))
+	})
+
+	Convey("Set duration, byte size and time", t, func() {
+		c, err := LoadFromData([]byte(""))
+		So(err, ShouldBeNil)
+
+		So(c.SetDuration("", "timeout", 90*time.Second), ShouldBeTrue)
+		So(c.MustValue("", "timeout"), ShouldEqual, "1m30s")
+
+		for size, value := range map[int64]string{
+			0: "0B", 512: "512B", 1000: "1kB", 1024: "1KiB", 10000000: "10MB",
+			3 << 29: "1536MiB", 1 << 40: "1TiB", 1500: "1500B",
+			-1024: "-1KiB", -1: "-1B", math.MinInt64: "-8EiB", math.MaxInt64: "9223372036854775807B",
+		} {
+			c.SetByteSize("", "size", size)
+			So(c.MustValue("", "size"), ShouldEqual, value)
+			So(c.MustByteSize("", "size"), ShouldEqual, size)
+		}
+
+		date := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+		c.SetTime("", "date", date)
+		So(c.MustValue("", "date"), ShouldEqual, "2024-02-03T04:05:06Z")
+		c.SetTimeLayouts("2006-01-02")
+		c.SetTime("", "date", date)
+		So(c.MustValue("", "date"), ShouldEqual, "2024-02-03")
+	})
+}
+
+func TestNetTypes(t *testing.T) {
+	Convey("Return network typed values", t, func() {
+		data := `upstream = https://example.com:8443/api?x=1
+relative = /api
+ip = 10.0.0.1
+ip6 = ::1
+bad_ip = 10.0.0.256
+cidr = 10.0.0.0/8
+listen = 0.0.0.0:8080
+any = :80
+ipv6_listen = [::1]:443
+no_port = localhost
+bad_port = localhost:http
+allow = 10.0.0.0/8, 192.168.0.0/16
+bad_allow = 10.0.0.0/8, 192.168.0.0
+dns = 1.1.1.1 8.8.8.8
+mirrors = https://a.example.com,https://b.example.com
+`
+		c, err := LoadFromReader(bytes.NewBufferString(data))
+		So(err, ShouldBeNil)
+
+		u, err := c.URL("", "upstream")
+		So(err, ShouldBeNil)
+		So(u.Scheme, ShouldEqual, "https")
+		So(u.Host, ShouldEqual, "example.com:8443")
+		So(u.Path, ShouldEqual, "/api")
+		_, err = c.URL("", "relative")
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'relative': cannot convert value '/api': missing scheme in URL")
+
+		addr, err := c.Addr("", "ip")
+		So(err, ShouldBeNil)
+		So(addr == netip.MustParseAddr("10.0.0.1"), ShouldBeTrue)
+		addr, err = c.Addr("", "ip6")
+		So(err, ShouldBeNil)
+		So(addr.Is6(), ShouldBeTrue)
+		_, err = c.Addr("", "bad_ip")
+		verr, ok := err.(ValueError)
+		So(ok, ShouldBeTrue)
+		So(verr.Key, ShouldEqual, "bad_ip")
+
+		prefix, err := c.Prefix("", "cidr")
+		So(err, ShouldBeNil)
+		So(prefix.Contains(netip.MustParseAddr("10.1.2.3")), ShouldBeTrue)
+		_, err = c.Prefix("", "ip")
+		So(err, ShouldNotBeNil)
+
+		host, port, err := c.HostPort("", "listen")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "0.0.0.0")
+		So(port, ShouldEqual, 8080)
+		host, port, err = c.HostPort("", "any")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "")
+		So(port, ShouldEqual, 80)
+		host, port, err = c.HostPort("", "ipv6_listen")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "::1")
+		So(port, ShouldEqual, 443)
+		_, _, err = c.HostPort("", "no_port")
+		So(err, ShouldNotBeNil)
+		_, _, err = c.HostPort("", "bad_port")
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'bad_port': cannot convert value 'localhost:http': invalid port 'http'")
+		_, _, err = c.HostPort("", "missing")
+		So(err, ShouldResemble, KeyError{DEFAULT_SECTION, "missing", GetError{ERR_KEY_NOT_FOUND, "missing"}})
+		_, err = c.URL("net", "upstream")
+		So(err.Error(), ShouldEqual, "section 'net' key 'upstream': section 'net' not found")
+		_, err = c.Prefixes("", "missing", ",")
+		So(err.Error(), ShouldEqual, "section 'DEFAULT' key 'missing': key 'missing' not found")
+
+		prefixes, err := c.Prefixes("", "allow", ",")
+		So(err, ShouldBeNil)
+		So(fmt.Sprint(prefixes), ShouldEqual, "[10.0.0.0/8 192.168.0.0/16]")
+		_, err = c.Prefixes("", "bad_allow", ",")
+		So(err.(ListError).Index, ShouldEqual, 1)
+		addrs, err := c.Addrs("", "dns", " ")
+		So(err, ShouldBeNil)
+		So(fmt.Sprint(addrs), ShouldEqual, "[1.1.1.1 8.8.8.8]")
+		urls, err := c.URLs("", "mirrors", ",")
+		So(err, ShouldBeNil)
+		So(len(urls), ShouldEqual, 2)
+		So(urls[1].Host, ShouldEqual, "b.example.com")
+	})
+}
+
 func TestLoadFromData(t *testing.T) {
 	Convey("Load config file from data", t, func() {
 		c, err := LoadFromData([]byte(""))
@@ -382,6 +2020,202 @@ func TestLoadFromReader(t *testing.T) {
 	})
 }
 
+type testChild struct {
+	Age     int    `ini:"age"`
+	Married bool   `ini:"married"`
+	Sex     string `ini:"sex"`
+}
+
+type testParent struct {
+	Name  string    `ini:"name"`
+	Age   int       `ini:"age"`
+	Money float64   `ini:"money"`
+	Child testChild `ini:"child"`
+}
+
+type testConfig struct {
+	Google string `ini:"google"`
+	Search string `ini:"search"`
+	Demo   struct {
+		Array []int `ini:"array_key"`
+	}
+	Parent  testParent `ini:"parent"`
+	Skipped string     `ini:"-"`
+}
+
+func TestMapTo(t *testing.T) {
+	Convey("Map configuration to struct", t, func() {
+		c, err := LoadConfigFile("testdata/conf.ini")
+		So(err, ShouldBeNil)
+		So(c, ShouldNotBeNil)
+
+		Convey("Map with nested sections", func() {
+			cfg := &testConfig{Skipped: "untouched"}
+			So(c.MapTo(cfg), ShouldBeNil)
+			So(cfg.Google, ShouldEqual, "www.google.com")
+			So(cfg.Search, ShouldEqual, "http://www.google.com")
+			So(cfg.Demo.Array, ShouldResemble, []int{1, 2, 3, 4, 5})
+			So(cfg.Parent.Name, ShouldEqual, "john")
+			So(cfg.Parent.Age, ShouldEqual, 32)
+			So(cfg.Parent.Money, ShouldEqual, 1.25)
+			So(cfg.Parent.Child.Age, ShouldEqual, 3)
+			So(cfg.Parent.Child.Married, ShouldBeTrue)
+			So(cfg.Parent.Child.Sex, ShouldEqual, "male")
+			So(cfg.Skipped, ShouldEqual, "untouched")
+		})
+
+		Convey("Map a single section", func() {
+			child := new(testChild)
+			So(c.MapToSection("parent.child", child), ShouldBeNil)
+			So(child.Age, ShouldEqual, 3)
+		})
+
+		Convey("Report all conversion failures", func() {
+			cfg := new(struct {
+				Name  int  `ini:"name"`
+				Empty bool `ini:"empty_value"`
+			})
+			err := c.MapToSection("What's this?", cfg)
+			So(err, ShouldNotBeNil)
+			errs, ok := err.(MapError)
+			So(ok, ShouldBeTrue)
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Section, ShouldEqual, "What's this?")
+			So(errs[0].Key, ShouldEqual, "name")
+			So(errs[1].Key, ShouldEqual, "empty_value")
+		})
+
+		Convey("Map to invalid value", func() {
+			So(c.MapTo(testConfig{}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReflectFrom(t *testing.T) {
+	Convey("Reflect configuration from struct", t, func() {
+		type server struct {
+			Host    string        `ini:"host" comment:"Address to listen on"`
+			Ports   []int         `ini:"ports" delim:"|"`
+			Timeout time.Duration `ini:"timeout"`
+			Limits  struct {
+				Rate float64 `ini:"rate"`
+			} `ini:"limits" comment:"Rate limiting"`
+		}
+		type config struct {
+			Name   string `ini:"name"`
+			Debug  bool   `ini:"debug"`
+			Server server `ini:"server"`
+		}
+
+		cfg := config{Name: "app", Debug: true}
+		cfg.Server.Host = "0.0.0.0"
+		cfg.Server.Ports = []int{80, 443}
+		cfg.Server.Timeout = 30 * time.Second
+		cfg.Server.Limits.Rate = 0.5
+
+		c, err := LoadFromReader(bytes.NewBuffer(nil))
+		So(err, ShouldBeNil)
+
+		Convey("Reflect without comments", func() {
+			So(c.ReflectFrom(&cfg), ShouldBeNil)
+			So(c.GetSectionList(), ShouldResemble,
+				[]string{"DEFAULT", "server", "server.limits"})
+			So(c.GetKeyList("server"), ShouldResemble, []string{"host", "ports", "timeout"})
+			So(c.MustValue("server", "ports"), ShouldEqual, "80|443")
+			So(c.MustValue("server", "timeout"), ShouldEqual, "30s")
+			So(c.GetKeyComments("server", "host"), ShouldEqual, "")
+
+			Convey("Map back to struct", func() {
+				var got config
+				So(c.MapTo(&got), ShouldBeNil)
+				So(got, ShouldResemble, cfg)
+			})
+		})
+
+		Convey("Reflect with comments", func() {
+			So(c.ReflectFrom(cfg, true), ShouldBeNil)
+			So(c.GetKeyComments("server", "host"), ShouldEqual, "; Address to listen on")
+			So(c.GetSectionComments("server.limits"), ShouldEqual, "; Rate limiting")
+
+			var buf bytes.Buffer
+			So(SaveConfigData(c, &buf), ShouldBeNil)
+			So(buf.String(), ShouldStartWith, "name = app"+LineBreak+"debug = true"+LineBreak)
+		})
+
+		Convey("Reflect from invalid value", func() {
+			So(c.ReflectFrom(1), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Reflect and map pointer fields", t, func() {
+		type sub struct {
+			Rate float64 `ini:"rate"`
+		}
+		type config struct {
+			Port  *int `ini:"port"`
+			Debug *bool
+			Sub   *sub `ini:"sub"`
+			Nil   *sub `ini:"nil"`
+		}
+
+		port := 8080
+		c, err := LoadFromReader(bytes.NewBuffer(nil))
+		So(err, ShouldBeNil)
+		So(c.ReflectFrom(config{Port: &port, Sub: &sub{0.5}}), ShouldBeNil)
+		So(c.GetSectionList(), ShouldResemble, []string{"DEFAULT", "sub"})
+		So(c.GetKeyList("DEFAULT"), ShouldResemble, []string{"port"})
+		So(c.MustValue("sub", "rate"), ShouldEqual, "0.5")
+
+		var got config
+		So(c.MapTo(&got), ShouldBeNil)
+		So(*got.Port, ShouldEqual, 8080)
+		So(got.Debug, ShouldBeNil)
+		So(got.Sub, ShouldResemble, &sub{0.5})
+		So(got.Nil, ShouldBeNil)
+	})
+
+	Convey("Reflect and map lists with quoted elements", t, func() {
+		type config struct {
+			Names []string `ini:"names"`
+		}
+
+		c, err := LoadFromReader(bytes.NewBuffer(nil))
+		So(err, ShouldBeNil)
+		So(c.ReflectFrom(config{[]string{"a,b", "c"}}), ShouldBeNil)
+		So(c.MustValue("", "names"), ShouldEqual, `"a,b",c`)
+		So(c.MustStrings("", "names", ","), ShouldResemble, []string{"a,b", "c"})
+
+		var got config
+		So(c.MapTo(&got), ShouldBeNil)
+		So(got.Names, ShouldResemble, []string{"a,b", "c"})
+		So(GetOr(c, "", "names", []string(nil)), ShouldResemble, []string{"a,b", "c"})
+	})
+
+	Convey("Reflect and map times with custom layouts", t, func() {
+		type config struct {
+			Date  time.Time   `ini:"date"`
+			Dates []time.Time `ini:"dates"`
+		}
+
+		c, err := LoadFromReader(bytes.NewBuffer(nil))
+		So(err, ShouldBeNil)
+		c.SetTimeLayouts("2006-01-02")
+		date := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+		So(c.ReflectFrom(config{date, []time.Time{date, date.AddDate(0, 0, 1)}}), ShouldBeNil)
+		So(c.MustValue("", "date"), ShouldEqual, "2020-01-02")
+		So(c.MustValue("", "dates"), ShouldEqual, "2020-01-02,2020-01-03")
+
+		var got config
+		So(c.MapTo(&got), ShouldBeNil)
+		So(got.Date.Equal(date), ShouldBeTrue)
+		So(got.Dates, ShouldHaveLength, 2)
+		So(got.Dates[1].Equal(date.AddDate(0, 0, 1)), ShouldBeTrue)
+		value, err := Get[time.Time](c, "", "date")
+		So(err, ShouldBeNil)
+		So(value.Equal(date), ShouldBeTrue)
+	})
+}
+
 func Benchmark_GetValue(b *testing.B) {
 	c, _ := LoadConfigFile("testdata/conf.ini")
 	c.BlockMode = false
@@ -396,3 +2230,14 @@ func Benchmark_SetValue(b *testing.B) {
 		c.SetValue("parent", "money", "10")
 	}
 }
+
+func Benchmark_SetValueLarge(b *testing.B) {
+	c, _ := LoadFromReader(strings.NewReader(""))
+	for i := 0; i < 5000; i++ {
+		c.SetValue(fmt.Sprintf("section%d", i%50), fmt.Sprintf("key%d", i), "value")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.SetValue("section0", "key0", "10")
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Variable regexp pattern: %(variable)s or %(section::key)s
+var varPattern = regexp.MustCompile(`%\(([^\)]+)\)s`)
+
+// Extended variable regexp pattern: ${ENV:NAME}, ${NAME}, ${NAME:-default}
+// or ${section:key}
+var envPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// InterpolationMode is the syntax of variables substituted in values.
+type InterpolationMode int
+
+const (
+	// Python syntax: %(variable)s and %(section::key)s.
+	INTERPOLATION_PYTHON InterpolationMode = iota
+	// Values are returned as they are.
+	INTERPOLATION_NONE
+	// Extended syntax: ${ENV:NAME}, ${NAME}, ${NAME:-default} and ${section:key}.
+	INTERPOLATION_EXTENDED
+	// Both Python and extended syntax.
+	INTERPOLATION_ALL
+
+	// Alias of INTERPOLATION_PYTHON, values with ${...} are returned as they are.
+	INTERPOLATION_DEFAULT = INTERPOLATION_PYTHON
+)
+
+// A Resolver substitutes variables in values by its own syntax.
+type Resolver interface {
+	// Resolve returns the value of key in given section with variables substituted,
+	// raw is the value as it is in the configuration. Values of other keys can be
+	// got by lookup, which returns InterpolationError for cyclic references.
+	Resolve(section, key, raw string, lookup func(section, key string) (string, error)) (string, error)
+}
+
+// SetInterpolation sets the syntax of variables substituted in values,
+// which is INTERPOLATION_PYTHON by default. Extended syntax reads
+// environment variables, so it has to be enabled explicitly by
+// INTERPOLATION_EXTENDED or INTERPOLATION_ALL.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetInterpolation(mode InterpolationMode) {
+	c.interpolation = mode
+}
+
+// SetResolver sets a resolver that substitutes variables in values
+// instead of the built-in syntax, or unsets it if r is nil.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetResolver(r Resolver) {
+	c.resolver = r
+}
+
+// SetEnvLookup sets the function used to look up environment variables
+// referenced in values, which is os.LookupEnv by default.
+// It is useful to inject variables in tests.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetEnvLookup(fn func(name string) (string, bool)) {
+	c.lookupEnv = fn
+}
+
+// getEnv returns the value of environment variable.
+func (c *ConfigFile) getEnv(name string) (string, bool) {
+	if c.lookupEnv != nil {
+		return c.lookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// SetLenientInterpolation sets whether undefined variables are replaced by
+// empty string and cyclic references are followed up to _DEPTH_VALUES times
+// silently, instead of returning InterpolationError.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetLenientInterpolation(lenient bool) {
+	c.lenient = lenient
+}
+
+// A resolver substitutes variables in values of a snapshot.
+type resolver struct {
+	c     *ConfigFile
+	s     *snapshot
+	stack []string // References being resolved, in form of "section::key".
+}
+
+func (c *ConfigFile) newResolver(s *snapshot) *resolver {
+	return &resolver{c: c, s: s}
+}
+
+// resolve returns the value of key in given section or its parent sections
+// with variables substituted.
+func (r *resolver) resolve(section, key string) (string, error) {
+	value, section, err := r.c.rawValue(r.s, section, key)
+	if err != nil {
+		return "", err
+	}
+	return r.expand(section, key, value)
+}
+
+// expand substitutes variables in value of key in given section.
+// Cyclic references are left as they are in lenient mode.
+func (r *resolver) expand(section, key, value string) (string, error) {
+	ref := section + "::" + key
+	for i := range r.stack {
+		if r.stack[i] == ref {
+			if r.c.lenient {
+				return value, nil
+			}
+			path := append(append([]string(nil), r.stack[i:]...), ref)
+			return "", InterpolationError{ERR_INTERPOLATION_CYCLE, section, key, ref, path}
+		}
+	}
+	if len(r.stack) >= _DEPTH_VALUES {
+		return value, nil
+	}
+
+	r.stack = append(r.stack, ref)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	if r.c.resolver != nil {
+		return r.c.resolver.Resolve(section, key, value, r.resolve)
+	}
+
+	var err error
+	mode := r.c.interpolation
+	if mode == INTERPOLATION_PYTHON || mode == INTERPOLATION_ALL {
+		if value, err = r.interpolate(section, key, value); err != nil {
+			return "", err
+		}
+	}
+	if mode == INTERPOLATION_EXTENDED || mode == INTERPOLATION_ALL {
+		if value, err = r.expandEnv(section, key, value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// undefined returns the error of undefined variable,
+// or nil if undefined variables are allowed.
+func (r *resolver) undefined(section, key, name string) error {
+	if r.c.lenient {
+		return nil
+	}
+	return InterpolationError{Reason: ERR_UNDEFINED_VARIABLE, Section: section, Key: key, Name: name}
+}
+
+// interpolate substitutes variables in form of %(variable)s in value
+// of key in given section. Variables are searched in DEFAULT section first
+// and then in the same section, or the reverse if SetDefaultFallback is set,
+// or in given section by %(section::key)s.
+func (r *resolver) interpolate(section, key, value string) (string, error) {
+	var err error
+	value = varPattern.ReplaceAllStringFunc(value, func(vr string) string {
+		if err != nil {
+			return vr
+		}
+
+		// Take off leading '%(' and trailing ')s'.
+		noption := vr[2 : len(vr)-2]
+
+		var nvalue string
+		if i := strings.Index(noption, "::"); i > -1 {
+			// Search variable in given section.
+			nvalue, err = r.resolve(noption[:i], noption[i+2:])
+		} else if r.c.defaultFallback {
+			// Search variable in the same section and then default section.
+			nvalue, err = r.resolve(section, noption)
+		} else {
+			// Search variable in default section.
+			nvalue, err = r.resolve(DEFAULT_SECTION, noption)
+			if _, ok := err.(GetError); ok && section != DEFAULT_SECTION {
+				// Search in the same section.
+				if raw, ok := r.s.data[section][noption]; ok {
+					nvalue, err = r.expand(section, noption, raw)
+				}
+			}
+		}
+		if _, ok := err.(GetError); ok {
+			err = r.undefined(section, key, noption)
+		}
+		return nvalue
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// expandEnv substitutes environment variables and keys of other sections
+// in value of key in given section.
+// References in forms of ${ENV:NAME} and ${NAME} are replaced by the value
+// of variable NAME.
+// Reference in form of ${NAME:-default} is replaced by the default value
+// if variable NAME is not set or empty.
+// Reference in form of ${section:key} is replaced by the value of key
+// in given section.
+func (r *resolver) expandEnv(section, key, value string) (string, error) {
+	var err error
+	value = envPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+
+		// Take off leading '${' and trailing '}'.
+		name := ref[2 : len(ref)-1]
+
+		if i := strings.Index(name, ":-"); i > -1 {
+			if val, _ := r.c.getEnv(name[:i]); len(val) > 0 {
+				return val
+			}
+			return name[i+2:]
+		}
+
+		envName := name
+		if strings.HasPrefix(name, "ENV:") {
+			envName = name[4:]
+		} else if i := strings.Index(name, ":"); i > -1 {
+			var val string
+			val, err = r.resolve(name[:i], name[i+1:])
+			if _, ok := err.(GetError); ok {
+				err = r.undefined(section, key, name)
+			}
+			return val
+		}
+		val, ok := r.c.getEnv(envName)
+		if !ok {
+			err = r.undefined(section, key, name)
+		}
+		return val
+	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// InterpolationError occurs when a variable in value cannot be substituted.
+type InterpolationError struct {
+	Reason  ParseError
+	Section string   // Section of the key whose value has the variable.
+	Key     string   // Key whose value has the variable.
+	Name    string   // Name of the variable.
+	Path    []string // References in the cycle, in form of "section::key".
+}
+
+// Error implements Error interface.
+func (err InterpolationError) Error() string {
+	switch err.Reason {
+	case ERR_UNDEFINED_VARIABLE:
+		return fmt.Sprintf("section '%s' key '%s': undefined variable '%s'", err.Section, err.Key, err.Name)
+	case ERR_INTERPOLATION_CYCLE:
+		return fmt.Sprintf("section '%s' key '%s': interpolation cycle: %s",
+			err.Section, err.Key, strings.Join(err.Path, " -> "))
+	}
+	return "invalid interpolation error"
+}
@@ -0,0 +1,283 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_DELIMITER is used by list methods when the given delimiter is empty.
+const DEFAULT_DELIMITER = ","
+
+// ListError occurs when an element of a list value cannot be converted.
+type ListError struct {
+	Section string
+	Key     string
+	Index   int    // Index of the bad element, -1 if the list cannot be split.
+	Value   string // The bad element, or the whole value if the list cannot be split.
+	Err     error
+}
+
+// Error implements Error interface.
+func (err ListError) Error() string {
+	if err.Index < 0 {
+		return fmt.Sprintf("section '%s' key '%s': cannot split list '%s': %v",
+			err.Section, err.Key, err.Value, err.Err)
+	}
+	return fmt.Sprintf("section '%s' key '%s': element %d '%s': %v",
+		err.Section, err.Key, err.Index, err.Value, err.Err)
+}
+
+// Unwrap returns the underlying conversion error.
+func (err ListError) Unwrap() error {
+	return err.Err
+}
+
+// splitList splits value by delimiter and trims spaces around elements.
+// An element can be put in double quotes to contain the delimiter,
+// quotes and backslashes are escaped by backslash inside.
+// Empty value is an empty list.
+func splitList(value, delim string) ([]string, error) {
+	if len(delim) == 0 {
+		delim = DEFAULT_DELIMITER
+	}
+	vals := []string{}
+	if len(strings.TrimSpace(value)) == 0 {
+		return vals, nil
+	}
+
+	for {
+		value = strings.TrimLeft(value, " \t")
+		var elem string
+		if strings.HasPrefix(value, `"`) {
+			var buf strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				buf.WriteByte(value[i])
+			}
+			if i == len(value) {
+				return nil, errors.New("unclosed quote")
+			}
+			value = value[i+1:]
+			if rest := strings.TrimLeft(value, " \t"); !strings.HasPrefix(value, delim) {
+				if len(rest) > 0 && !strings.HasPrefix(rest, delim) {
+					return nil, fmt.Errorf("unexpected '%s' after quoted element", rest)
+				}
+				value = rest
+			}
+			elem = buf.String()
+		} else {
+			i := strings.Index(value, delim)
+			if i == -1 {
+				i = len(value)
+			}
+			elem = strings.TrimSpace(value[:i])
+			value = value[i:]
+		}
+		vals = append(vals, elem)
+
+		if len(value) == 0 {
+			return vals, nil
+		}
+		value = value[len(delim):]
+	}
+}
+
+// joinList joins elements by delimiter, which is the reverse of splitList.
+// Elements are quoted only when they cannot be split back as they are.
+func joinList(vals []string, delim string) string {
+	if len(delim) == 0 {
+		delim = DEFAULT_DELIMITER
+	}
+	elems := make([]string, len(vals))
+	for i, val := range vals {
+		if len(val) == 0 || strings.Contains(val, delim) || strings.HasPrefix(val, `"`) ||
+			strings.TrimSpace(val) != val {
+			val = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + `"`
+		}
+		elems[i] = val
+	}
+	return strings.Join(elems, delim)
+}
+
+// getList returns the list value of given section-key,
+// with each element converted by parse.
+// It returns KeyError if the section or key does not exist.
+func getList[T any](c *ConfigFile, section, key, delim string, parse func(string) (T, error)) ([]T, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return nil, keyError(section, key, err)
+	}
+	elems, err := splitList(value, delim)
+	if err != nil {
+		return nil, ListError{section, key, -1, value, err}
+	}
+
+	vals := make([]T, len(elems))
+	for i, elem := range elems {
+		if vals[i], err = parse(elem); err != nil {
+			return nil, ListError{section, key, i, elem, err}
+		}
+	}
+	return vals, nil
+}
+
+// mustList returns the default list if error occurs and it is given,
+// or an empty list otherwise.
+func mustList[T any](vals []T, err error, defaultVal []T) []T {
+	if err != nil {
+		if len(defaultVal) > 0 {
+			return defaultVal
+		}
+		return []T{}
+	}
+	return vals
+}
+
+// setList formats each element by format and sets the joined value.
+func setList[T any](c *ConfigFile, section, key, delim string, vals []T, format func(T) string) bool {
+	elems := make([]string, len(vals))
+	for i, val := range vals {
+		elems[i] = format(val)
+	}
+	return c.SetValue(section, key, joinList(elems, delim))
+}
+
+func formatFloat64(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+func formatDuration(d time.Duration) string { return d.String() }
+
+// Strings returns list value split by delimiter, which is "," if empty.
+// Elements can be put in double quotes to contain the delimiter.
+// It returns ListError if the list cannot be split.
+func (c *ConfigFile) Strings(section, key, delim string) ([]string, error) {
+	return getList(c, section, key, delim, func(s string) (string, error) { return s, nil })
+}
+
+// Ints returns list of int type values, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Ints(section, key, delim string) ([]int, error) {
+	return getList(c, section, key, delim, parseInt)
+}
+
+// Int64s returns list of int64 type values, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Int64s(section, key, delim string) ([]int64, error) {
+	return getList(c, section, key, delim, parseInt64)
+}
+
+// Float64s returns list of float64 type values, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Float64s(section, key, delim string) ([]float64, error) {
+	return getList(c, section, key, delim, parseFloat64)
+}
+
+// Bools returns list of bool type values, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Bools(section, key, delim string) ([]bool, error) {
+	return getList(c, section, key, delim, c.parseBool)
+}
+
+// Durations returns list of time.Duration type values, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Durations(section, key, delim string) ([]time.Duration, error) {
+	return getList(c, section, key, delim, time.ParseDuration)
+}
+
+// MustStrings always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustStrings(section, key, delim string, defaultVal ...string) []string {
+	vals, err := c.Strings(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// MustInts always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustInts(section, key, delim string, defaultVal ...int) []int {
+	vals, err := c.Ints(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// MustInt64s always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustInt64s(section, key, delim string, defaultVal ...int64) []int64 {
+	vals, err := c.Int64s(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// MustFloat64s always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustFloat64s(section, key, delim string, defaultVal ...float64) []float64 {
+	vals, err := c.Float64s(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// MustBools always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustBools(section, key, delim string, defaultVal ...bool) []bool {
+	vals, err := c.Bools(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// MustDurations always returns list without error,
+// it returns empty list if error occurs, or the default values if given.
+func (c *ConfigFile) MustDurations(section, key, delim string, defaultVal ...time.Duration) []time.Duration {
+	vals, err := c.Durations(section, key, delim)
+	return mustList(vals, err, defaultVal)
+}
+
+// SetStrings joins values by delimiter and sets it to given section-key,
+// elements are quoted when needed so they can be split back by Strings.
+// It returns true if the key and value were inserted.
+func (c *ConfigFile) SetStrings(section, key, delim string, vals ...string) bool {
+	return setList(c, section, key, delim, vals, func(s string) string { return s })
+}
+
+// SetInts joins int type values by delimiter and sets it to given section-key.
+func (c *ConfigFile) SetInts(section, key, delim string, vals ...int) bool {
+	return setList(c, section, key, delim, vals, strconv.Itoa)
+}
+
+// SetInt64s joins int64 type values by delimiter and sets it to given section-key.
+func (c *ConfigFile) SetInt64s(section, key, delim string, vals ...int64) bool {
+	return setList(c, section, key, delim, vals, func(i int64) string { return strconv.FormatInt(i, 10) })
+}
+
+// SetFloat64s joins float64 type values by delimiter and sets it to given section-key.
+func (c *ConfigFile) SetFloat64s(section, key, delim string, vals ...float64) bool {
+	return setList(c, section, key, delim, vals, formatFloat64)
+}
+
+// SetBools joins bool type values by delimiter and sets it to given section-key.
+func (c *ConfigFile) SetBools(section, key, delim string, vals ...bool) bool {
+	return setList(c, section, key, delim, vals, strconv.FormatBool)
+}
+
+// SetDurations joins time.Duration type values by delimiter and sets it to given section-key.
+func (c *ConfigFile) SetDurations(section, key, delim string, vals ...time.Duration) bool {
+	return setList(c, section, key, delim, vals, formatDuration)
+}
@@ -0,0 +1,209 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileStat is the state of a file when it was loaded or saved.
+type fileStat struct {
+	hash    [sha256.Size]byte // Hash of content.
+	modTime time.Time         // Modification time of file.
+}
+
+// newFileStat returns the state of file which has given content.
+func newFileStat(filename string, data []byte) fileStat {
+	stat := fileStat{hash: sha256.Sum256(data)}
+	if fi, err := os.Stat(filename); err == nil {
+		stat.modTime = fi.ModTime()
+	}
+	return stat
+}
+
+// fileKey returns the absolute path of file, so that states of a file
+// are found by any path of it.
+func fileKey(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filepath.Clean(filename)
+}
+
+// ConflictError occurs when a file has been changed on disk
+// since it was loaded or saved.
+type ConflictError struct {
+	File     string
+	ModTime  time.Time // Modification time of file when it was loaded or saved, zero if never.
+	Conflict []Change  // Sections and keys changed on both sides, only set by merging.
+}
+
+// Error implements Error interface.
+func (err ConflictError) Error() string {
+	if len(err.Conflict) > 0 {
+		return fmt.Sprintf("file '%s' has been changed on disk, %d conflicts when merging", err.File, len(err.Conflict))
+	}
+	return fmt.Sprintf("file '%s' has been changed on disk", err.File)
+}
+
+// checkFile returns ConflictError if the file has been changed on disk
+// since it was loaded or saved, or it was never loaded but exists.
+func (s *snapshot) checkFile(filename string) error {
+	stat, ok := s.fileStats[fileKey(filename)]
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if ok {
+			// Deleted on disk.
+			return ConflictError{File: filename, ModTime: stat.modTime}
+		}
+		return nil
+	}
+
+	if !ok || sha256.Sum256(data) != stat.hash {
+		return ConflictError{File: filename, ModTime: stat.modTime}
+	}
+	return nil
+}
+
+// isLoaded returns true if the file is one of the files loaded.
+func (s *snapshot) isLoaded(filename string) bool {
+	for _, name := range s.fileNames {
+		if len(name) > 0 && fileKey(name) == fileKey(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveConfigFileChecked is like SaveConfigFile, but returns ConflictError
+// and writes nothing if the file has been changed on disk since it was loaded
+// or saved by the configuration, so that changes of others are not lost.
+func SaveConfigFileChecked(c *ConfigFile, filename string) error {
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		if err := s.checkFile(filename); err != nil {
+			return nil, err
+		}
+		return c.saveFile(s, filename)
+	})
+}
+
+// SaveConfigFileMerged is like SaveConfigFileChecked, but if the file has
+// been changed on disk, it reloads files and merges changes made to the
+// configuration since they were loaded or saved into them, then saves the result.
+// It returns ConflictError with keys that were changed differently on both sides,
+// and both the configuration and the file are unchanged in that case.
+func SaveConfigFileMerged(c *ConfigFile, filename string) error {
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		err := s.checkFile(filename)
+		if err == nil {
+			return c.saveFile(s, filename)
+		}
+		cerr, ok := err.(ConflictError)
+		if !ok || s.base == nil {
+			return nil, err
+		}
+
+		theirs, err := loadFiles(s.fileNames, s.options)
+		if err != nil {
+			return nil, err
+		}
+		merged, conflict := mergeSnapshots(s.base, s, theirs)
+		if len(conflict) > 0 {
+			cerr.Conflict = conflict
+			return nil, cerr
+		}
+		return c.saveFile(merged, filename)
+	})
+}
+
+// mergeSnapshots applies changes from base to ours onto theirs,
+// and returns the result with keys that were changed differently on both sides.
+func mergeSnapshots(base, ours, theirs *snapshot) (*snapshot, []Change) {
+	merged := theirs.clone()
+	var conflict []Change
+
+	sections := make(map[string]bool)
+	for section := range base.data {
+		sections[section] = true
+	}
+	for section := range ours.data {
+		sections[section] = true
+	}
+
+	for section := range sections {
+		keys := make(map[string]bool)
+		for key := range base.data[section] {
+			keys[key] = true
+		}
+		for key := range ours.data[section] {
+			keys[key] = true
+		}
+
+		for key := range keys {
+			baseVal, inBase := base.data[section][key]
+			ourVal, inOurs := ours.data[section][key]
+			if inBase == inOurs && baseVal == ourVal {
+				// Not changed by us.
+				continue
+			}
+
+			theirVal, inTheirs := theirs.data[section][key]
+			if (inTheirs == inBase && theirVal == baseVal) || (inTheirs == inOurs && theirVal == ourVal) {
+				if inOurs {
+					merged.setValue(section, key, ourVal)
+					merged.deleteKeySource(section, key)
+					if ours.autoKeys[section][key] {
+						merged.markAutoKey(section, key)
+					}
+				} else {
+					merged.deleteKey(section, key)
+				}
+				continue
+			}
+			conflict = append(conflict, Change{section, key})
+		}
+
+		// Section deleted by us and all its keys are gone.
+		if _, ok := ours.data[section]; !ok && len(merged.data[section]) == 0 {
+			merged.deleteSection(section)
+		}
+	}
+
+	// Comments changed by us win.
+	for section := range sections {
+		if comments := ours.sectionComments[section]; comments != base.sectionComments[section] {
+			merged.setSectionComments(section, comments)
+		}
+		for key := range ours.data[section] {
+			if comments := ours.getKeyComments(section, key); comments != base.getKeyComments(section, key) {
+				merged.setKeyComments(section, key, comments)
+			}
+			if inline := ours.getInlineComment(section, key); inline != base.getInlineComment(section, key) {
+				merged.setInlineComment(section, key, inline)
+			}
+		}
+	}
+
+	sortChanges(conflict)
+	return merged, conflict
+}
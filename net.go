@@ -0,0 +1,120 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+)
+
+// getParsed returns the value of given section-key converted by parse,
+// KeyError if it does not exist, or ValueError if it cannot be converted.
+func getParsed[T any](c *ConfigFile, section, key string, parse func(string) (T, error)) (T, error) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	var zero T
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return zero, keyError(section, key, err)
+	}
+	v, err := parse(value)
+	if err != nil {
+		return zero, ValueError{section, key, value, err}
+	}
+	return v, nil
+}
+
+// parseURL parses absolute URL, which must have a scheme.
+func parseURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Scheme) == 0 {
+		return nil, errors.New("missing scheme in URL")
+	}
+	return u, nil
+}
+
+// hostPort is a pair of host and port.
+type hostPort struct {
+	host string
+	port int
+}
+
+// parseHostPort splits "host:port" and checks the port is a number
+// in range [0, 65535].
+func parseHostPort(value string) (hostPort, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return hostPort{}, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return hostPort{}, fmt.Errorf("invalid port '%s'", port)
+	}
+	return hostPort{host, int(n)}, nil
+}
+
+// URL returns absolute URL like "https://example.com/path",
+// or ValueError if it cannot be parsed or has no scheme.
+func (c *ConfigFile) URL(section, key string) (*url.URL, error) {
+	return getParsed(c, section, key, parseURL)
+}
+
+// Addr returns IPv4 or IPv6 address like "127.0.0.1" or "::1",
+// or ValueError if it cannot be parsed.
+func (c *ConfigFile) Addr(section, key string) (netip.Addr, error) {
+	return getParsed(c, section, key, netip.ParseAddr)
+}
+
+// Prefix returns IP network in CIDR notation like "10.0.0.0/8",
+// or ValueError if it cannot be parsed.
+func (c *ConfigFile) Prefix(section, key string) (netip.Prefix, error) {
+	return getParsed(c, section, key, netip.ParsePrefix)
+}
+
+// HostPort returns host and port of value like "0.0.0.0:8080",
+// "localhost:80" or "[::1]:443", where host may be empty as in ":8080".
+// It returns ValueError if it cannot be split or the port is not valid.
+func (c *ConfigFile) HostPort(section, key string) (host string, port int, err error) {
+	hp, err := getParsed(c, section, key, parseHostPort)
+	return hp.host, hp.port, err
+}
+
+// URLs returns list of URLs, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) URLs(section, key, delim string) ([]*url.URL, error) {
+	return getList(c, section, key, delim, parseURL)
+}
+
+// Addrs returns list of IP addresses, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Addrs(section, key, delim string) ([]netip.Addr, error) {
+	return getList(c, section, key, delim, netip.ParseAddr)
+}
+
+// Prefixes returns list of IP networks, see Strings for the format.
+// It returns ListError with the index of the bad element.
+func (c *ConfigFile) Prefixes(section, key, delim string) ([]netip.Prefix, error) {
+	return getList(c, section, key, delim, netip.ParsePrefix)
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"fmt"
+)
+
+// A Position describes where a section or key is in the source.
+type Position struct {
+	File   string // File name, empty if read from memory.
+	Line   int    // Line number, starting at 1.
+	Column int    // Column number in bytes, starting at 1.
+}
+
+// String returns the position in form of "file:line:column",
+// or "line:column" if there is no file name.
+func (p Position) String() string {
+	if len(p.File) == 0 {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// SectionPosition returns where the given section is declared first.
+// It returns false if the section was not read from any source.
+func (c *ConfigFile) SectionPosition(section string) (Position, bool) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	pos, ok := c.load().sectionPositions[section]
+	return pos, ok
+}
+
+// KeyPosition returns where the value of given section-key is read from,
+// which is the last place it is set when loading multiple files.
+// It returns false if the key was not read from any source,
+// or its value has been set by program since then.
+func (c *ConfigFile) KeyPosition(section, key string) (Position, bool) {
+	source, _, ok := c.KeySource(section, key)
+	return source.Position, ok
+}
+
+// A ValueSource describes a value and where it is read from.
+type ValueSource struct {
+	Position
+	Value string // Value as read, without variables unfolded.
+}
+
+// keySection returns the section that value of given section-key
+// is found in by GetValue, which may be a parent section or DEFAULT section.
+func (c *ConfigFile) keySection(s *snapshot, section, key string) (string, bool) {
+	_, found, err := getRawValue(s, section, key)
+	if err == nil {
+		return found, true
+	}
+	if !c.defaultFallback {
+		return "", false
+	}
+	if _, ok := s.data[section]; !ok {
+		return "", false
+	}
+	if _, ok := s.data[DEFAULT_SECTION][key]; ok {
+		return DEFAULT_SECTION, true
+	}
+	return "", false
+}
+
+// KeySource returns the source of value of given section-key,
+// which is the last place it is set when loading multiple files,
+// and the values it overrode in the order they were read.
+// The key is looked up in parent sections and DEFAULT section
+// in the same way as GetValue.
+// It returns false if the key was not read from any source,
+// or its value has been set by program since then.
+func (c *ConfigFile) KeySource(section, key string) (source ValueSource, shadowed []ValueSource, ok bool) {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	s := c.load()
+	if section, ok = c.keySection(s, section, key); !ok {
+		return ValueSource{}, nil, false
+	}
+	sources := s.keySources[section][key]
+	if len(sources) == 0 {
+		return ValueSource{}, nil, false
+	}
+	shadowed = make([]ValueSource, len(sources)-1)
+	copy(shadowed, sources)
+	return sources[len(sources)-1], shadowed, true
+}
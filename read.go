@@ -15,165 +15,397 @@
 package goconfig
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
+// LoadOptions changes how configuration files are read.
+type LoadOptions struct {
+	// Values starting with triple quotes that are not closed in the same line
+	// span following lines until the closing triple quotes, line breaks included.
+	MultiLineValues bool
+	// Indented lines following a key line continue its value and are joined
+	// by line breaks, an empty value on the key line itself is skipped.
+	// Continuation ends at a blank line, a comment or a line without indentation.
+	ContinuationLines bool
+	// Comments starting with ';' or '#' after whitespace at the end of key lines
+	// are inline comments of keys instead of part of values.
+	// Markers in quoted values are not recognized.
+	InlineComments bool
+	// Keys and values in double quotes are unquoted with escape sequences like "\n",
+	// "\t", "\"", "\\" and "\uXXXX" as Go string literals,
+	// and keys and values that need them are written in double quotes.
+	EscapeSequences bool
+}
+
 // Read reads an io.Reader and returns a configuration representation.
 // This representation can be queried with GetValue.
-func (c *ConfigFile) read(reader io.Reader) (err error) {
-	buf := bufio.NewReader(reader)
+// The fileName is used to record positions, and can be empty.
+func (s *snapshot) read(reader io.Reader, fileName string) (err error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
 
+	doc := newDocument()
 	// Handle BOM-UTF8.
 	// http://en.wikipedia.org/wiki/Byte_order_mark#Representations_of_byte_order_marks_by_encoding
-	mask, err := buf.Peek(3)
-	if err == nil && len(mask) >= 3 &&
-		mask[0] == 239 && mask[1] == 187 && mask[2] == 191 {
-		buf.Read(mask)
+	if len(data) >= 3 && data[0] == 239 && data[1] == 187 && data[2] == 191 {
+		data = data[3:]
+		doc.bom = true
 	}
 
-	count := 1 // Counter for auto increment.
 	// Current section name.
 	section := DEFAULT_SECTION
 	var comments string
+	var commentLines []*docLine // Lines of comments.
 	// Parse line-by-line
-	for {
-		line, err := buf.ReadString('\n')
-		line = strings.TrimSpace(line)
+	lines := splitLines(string(data))
+	for n := 0; n < len(lines); n++ {
+		raw := lines[n]
+		line := strings.TrimSpace(raw)
 		lineLengh := len(line) //[SWH|+]
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
+		lead := len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+		linePos := Position{fileName, n + 1, lead + 1}
 
-			// Reached end of file, if nothing to read then break,
-			// otherwise handle the last line.
-			if lineLengh == 0 {
-				break
-			}
-		}
+		dl := &docLine{raw: raw, section: section}
+		doc.lines = append(doc.lines, dl)
 
 		// switch written for readability (not performance)
 		switch {
 		case lineLengh == 0: // Empty line
 			continue
 		case line[0] == '#' || line[0] == ';': // Comment
+			dl.kind = lineComment
 			// Append comments
 			if len(comments) == 0 {
 				comments = line
 			} else {
 				comments += LineBreak + line
 			}
+			commentLines = append(commentLines, dl)
 			continue
 		case line[0] == '[' && line[lineLengh-1] == ']': // New section.
 			// Get section name.
 			section = strings.TrimSpace(line[1 : lineLengh-1])
+			if len(section) == 0 {
+				return ReadError{ERR_BLANK_SECTION_NAME, line, linePos}
+			}
+			dl.kind = lineSection
+			dl.section = section
+			doc.sections[section] = true
+			if _, ok := s.sectionPositions[section]; !ok {
+				s.sectionPositions[section] = linePos
+			}
 			// Set section comments and empty if it has comments.
 			if len(comments) > 0 {
-				c.SetSectionComments(section, comments)
+				s.setSectionComments(section, comments)
 				comments = ""
+				commentLines = ownComments(commentLines, dl)
 			}
+			dl.comments = s.sectionComments[section]
 			// Make section exist even though it does not have any key.
-			c.SetValue(section, " ", " ")
-			// Reset counter.
-			count = 1
+			s.setValue(section, " ", " ")
 			continue
-		case section == "": // No section defined so far
-			return ReadError{ERR_BLANK_SECTION_NAME, line}
 		default: // Other alternatives
-			var (
-				i        int
-				keyQuote string
-				key      string
-				valQuote string
-				value    string
-			)
-			//[SWH|+]:支持引号包围起来的字串
-			if line[0] == '"' {
-				if lineLengh >= 6 && line[0:3] == `"""` {
-					keyQuote = `"""`
-				} else {
-					keyQuote = `"`
-				}
-			} else if line[0] == '`' {
-				keyQuote = "`"
-			}
-			if keyQuote != "" {
-				qLen := len(keyQuote)
-				pos := strings.Index(line[qLen:], keyQuote)
-				if pos == -1 {
-					return ReadError{ERR_COULD_NOT_PARSE, line}
-				}
-				pos = pos + qLen
-				i = strings.IndexAny(line[pos:], "=:")
-				if i <= 0 {
-					return ReadError{ERR_COULD_NOT_PARSE, line}
-				}
-				i = i + pos
-				key = line[qLen:pos] //保留引号内的两端的空格
-			} else {
-				i = strings.IndexAny(line, "=:")
-				if i <= 0 {
-					return ReadError{ERR_COULD_NOT_PARSE, line}
-				}
-				key = strings.TrimSpace(line[0:i])
+			key, quoted, i := parseKey(line, s.options)
+			if i == -1 {
+				return ReadError{ERR_COULD_NOT_PARSE, line, linePos}
 			}
-			//[SWH|+];
 
-			// Check if it needs auto increment.
-			if key == "-" {
-				key = "#" + fmt.Sprint(count)
-				count++
+			// Check if it needs auto increment, which continues
+			// from the same section in previous lines and files.
+			if key == "-" && !quoted {
+				key = s.nextAutoKey(section)
 			}
 
-			//[SWH|+]:支持引号包围起来的字串
 			lineRight := strings.TrimSpace(line[i+1:])
-			lineRightLength := len(lineRight)
-			firstChar := ""
-			if lineRightLength >= 2 {
-				firstChar = lineRight[0:1]
-			}
-			if firstChar == "`" {
-				valQuote = "`"
-			} else if lineRightLength >= 6 && lineRight[0:3] == `"""` {
+			// Value starts from here in the original line.
+			start := lead + lineLengh - len(lineRight)
+
+			var value, valQuote string
+			var end int // Where the value ends in the original line.
+			if s.options.MultiLineValues && isMultiLineStart(lineRight) {
+				// Triple-quoted value spans multiple lines.
 				valQuote = `"""`
-			}
-			if valQuote != "" {
-				qLen := len(valQuote)
-				pos := strings.LastIndex(lineRight[qLen:], valQuote)
-				if pos == -1 {
-					return ReadError{ERR_COULD_NOT_PARSE, line}
+				value, end = readMultiLine(lines, &n, raw[start+3:])
+				if end == -1 {
+					return ReadError{ERR_COULD_NOT_PARSE, line, linePos}
 				}
-				pos = pos + qLen
-				value = lineRight[qLen:pos]
+				raw = strings.Join(lines[linePos.Line-1:n+1], "")
+				end += len(raw) - len(lines[n])
 			} else {
-				value = strings.TrimSpace(lineRight[0:])
+				var length int
+				value, valQuote, length = parseValue(lineRight, s.options)
+				if length == -1 {
+					return ReadError{ERR_COULD_NOT_PARSE, line, linePos}
+				}
+				end = start + length
+
+				// Value without quote or inline comment may continue.
+				if valQuote == "" && length == len(lineRight) && s.options.ContinuationLines {
+					var indent string
+					if value, indent = readContinuation(lines, &n, value); len(indent) > 0 {
+						dl.indent = indent
+						raw = strings.Join(lines[linePos.Line-1:n+1], "")
+						last := lines[n]
+						end = len(raw) - len(last) + len(strings.TrimRightFunc(last, unicode.IsSpace))
+					}
+				}
 			}
-			//[SWH|+];
 
-			c.SetValue(section, key, value)
+			s.setValue(section, key, value)
 			// Set key comments and empty if it has comments.
 			if len(comments) > 0 {
-				c.SetKeyComments(section, key, comments)
+				s.setKeyComments(section, key, comments)
 				comments = ""
+				commentLines = ownComments(commentLines, dl)
 			}
+
+			s.addKeySource(section, key, ValueSource{linePos, value})
+
+			if s.options.InlineComments {
+				inline := strings.TrimSpace(raw[end:])
+				if len(inline) > 0 && inline[0] != '#' && inline[0] != ';' {
+					// Not a comment after quoted value.
+					inline = ""
+				}
+				s.setInlineComment(section, key, inline)
+				dl.inline = inline
+			}
+
+			// Record where the value is in the original line.
+			dl.raw = raw
+			dl.kind = lineKey
+			dl.key = key
+			dl.value = value
+			dl.quote = valQuote
+			dl.comments = s.getKeyComments(section, key)
+			dl.prefix = raw[:start]
+			dl.suffix = raw[end:]
+			doc.sections[section] = true
 		}
+	}
 
-		// Reached end of file.
-		if err == io.EOF {
+	// Lines after the last section or key belong to the file,
+	// so they are kept even if the last section is deleted.
+	for i := len(doc.lines) - 1; i >= 0; i-- {
+		if doc.lines[i].kind == lineSection || doc.lines[i].kind == lineKey {
 			break
 		}
+		doc.lines[i].section = ""
+	}
+
+	// Only the first source is kept for saving.
+	if s.doc == nil {
+		s.doc = doc
 	}
 	return nil
 }
 
+// parseKey parses the key at the beginning of line, which is trimmed.
+// It returns the key, whether it is quoted and the index of separator,
+// or -1 if the key cannot be parsed.
+func parseKey(line string, opts LoadOptions) (key string, quoted bool, i int) {
+	if opts.EscapeSequences && isEscapeQuoted(line) {
+		n := closingQuote(line)
+		if n == -1 {
+			return "", true, -1
+		}
+		key, err := strconv.Unquote(line[:n])
+		if err != nil {
+			return "", true, -1
+		}
+		if i = strings.IndexAny(line[n:], "=:"); i == -1 {
+			return "", true, -1
+		}
+		return key, true, i + n
+	}
+
+	//[SWH|+]:支持引号包围起来的字串
+	var keyQuote string
+	if line[0] == '"' {
+		if len(line) >= 6 && line[0:3] == `"""` {
+			keyQuote = `"""`
+		} else {
+			keyQuote = `"`
+		}
+	} else if line[0] == '`' {
+		keyQuote = "`"
+	}
+	if keyQuote != "" {
+		qLen := len(keyQuote)
+		pos := strings.Index(line[qLen:], keyQuote)
+		if pos == -1 {
+			return "", true, -1
+		}
+		pos = pos + qLen
+		i = strings.IndexAny(line[pos:], "=:")
+		if i <= 0 {
+			return "", true, -1
+		}
+		return line[qLen:pos], true, i + pos //保留引号内的两端的空格
+	}
+
+	i = strings.IndexAny(line, "=:")
+	if i <= 0 {
+		return "", false, -1
+	}
+	return strings.TrimSpace(line[0:i]), false, i
+}
+
+// parseValue parses the value of a single line, lineRight is trimmed.
+// It returns the value, its quote and the length of lineRight it takes,
+// which excludes inline comment, or -1 if the value cannot be parsed.
+func parseValue(lineRight string, opts LoadOptions) (value, quote string, length int) {
+	if opts.EscapeSequences && isEscapeQuoted(lineRight) {
+		n := closingQuote(lineRight)
+		if n == -1 {
+			return "", `"`, -1
+		}
+		value, err := strconv.Unquote(lineRight[:n])
+		if err != nil {
+			return "", `"`, -1
+		}
+		return value, `"`, n
+	}
+	if opts.MultiLineValues && isMultiLineStart(lineRight) {
+		return "", `"""`, -1
+	}
+
+	//[SWH|+]:支持引号包围起来的字串
+	if len(lineRight) >= 2 && lineRight[0] == '`' {
+		quote = "`"
+	} else if len(lineRight) >= 6 && lineRight[0:3] == `"""` {
+		quote = `"""`
+	}
+	if quote != "" {
+		qLen := len(quote)
+		var pos int
+		if opts.InlineComments {
+			// Inline comment may have quotes.
+			pos = strings.Index(lineRight[qLen:], quote)
+		} else {
+			pos = strings.LastIndex(lineRight[qLen:], quote)
+		}
+		if pos == -1 {
+			return "", quote, -1
+		}
+		pos = pos + qLen
+		return lineRight[qLen:pos], quote, pos + qLen
+	}
+
+	if i := inlineCommentIndex(lineRight); opts.InlineComments && i > -1 {
+		value = strings.TrimSpace(lineRight[:i])
+		return value, "", len(value)
+	}
+	return lineRight, "", len(lineRight)
+}
+
+// isMultiLineStart returns true if value starts with triple quotes
+// which are not closed in the same line.
+func isMultiLineStart(value string) bool {
+	return strings.HasPrefix(value, `"""`) && !strings.Contains(value[3:], `"""`)
+}
+
+// isEscapeQuoted returns true if value starts with a double quote
+// but not triple quotes.
+func isEscapeQuoted(value string) bool {
+	return len(value) > 0 && value[0] == '"' && !strings.HasPrefix(value, `"""`)
+}
+
+// closingQuote returns the index after the double quote that closes
+// the one at the beginning of value, or -1 if it is not closed.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// inlineCommentIndex returns the index of inline comment in value,
+// or -1 if there is none. The marker must follow whitespace,
+// and must not be in double quotes at the beginning of value.
+func inlineCommentIndex(value string) int {
+	from := 1
+	if len(value) > 0 && value[0] == '"' {
+		if i := strings.IndexByte(value[1:], '"'); i > -1 {
+			from = i + 2
+		}
+	}
+	for i := from; i < len(value); i++ {
+		if (value[i] == ';' || value[i] == '#') && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return i
+		}
+	}
+	return -1
+}
+
+// readMultiLine reads a triple-quoted value from the rest of line after
+// the opening quote and following lines, and moves n to the line of closing
+// quote. It returns the value and where the closing quote ends in that line,
+// or -1 if the value is not closed.
+func readMultiLine(lines []string, n *int, rest string) (string, int) {
+	value := rest
+	for *n+1 < len(lines) {
+		*n++
+		if i := strings.Index(lines[*n], `"""`); i > -1 {
+			value += lines[*n][:i]
+			return strings.Replace(value, "\r\n", "\n", -1), i + 3
+		}
+		value += lines[*n]
+	}
+	return "", -1
+}
+
+// readContinuation reads indented lines following a key line
+// as continuation of value, and moves n to the last of them.
+// It returns the value and the indentation of continuation lines,
+// which is empty if there is none.
+func readContinuation(lines []string, n *int, value string) (string, string) {
+	var indent string
+	for *n+1 < len(lines) {
+		next := lines[*n+1]
+		line := strings.TrimSpace(next)
+		lead := len(next) - len(strings.TrimLeftFunc(next, unicode.IsSpace))
+		if len(line) == 0 || lead == 0 || line[0] == '#' || line[0] == ';' {
+			break
+		}
+
+		if len(indent) == 0 {
+			indent = next[:lead]
+		}
+		if len(value) == 0 {
+			value = line
+		} else {
+			value += "\n" + line
+		}
+		*n++
+	}
+	return value, indent
+}
+
+// ownComments sets owner of comment lines and returns an empty list.
+func ownComments(lines []*docLine, owner *docLine) []*docLine {
+	for _, l := range lines {
+		l.owner = owner
+	}
+	return lines[:0]
+}
+
 // LoadFromData accepts raw data directly from memory
 // and returns a new configuration representation.
 // Note that the configuration is written to the system
@@ -189,9 +421,9 @@ func LoadFromData(data []byte) (c *ConfigFile, err error) {
 		return nil, err
 	}
 
-	c = newConfigFile([]string{tmpName})
-	err = c.read(bytes.NewBuffer(data))
-	return c, err
+	s := newSnapshot([]string{tmpName}, LoadOptions{})
+	err = s.read(bytes.NewBuffer(data), "")
+	return newConfigFile(s), err
 }
 
 // LoadFromReader accepts raw data directly from a reader
@@ -199,24 +431,48 @@ func LoadFromData(data []byte) (c *ConfigFile, err error) {
 // You must use ReloadData to reload.
 // You cannot append files a configfile read this way.
 func LoadFromReader(in io.Reader) (c *ConfigFile, err error) {
-	c = newConfigFile([]string{""})
-	err = c.read(in)
-	return c, err
+	return LoadFromReaderWithOptions(LoadOptions{}, in)
 }
 
-func (c *ConfigFile) loadFile(fileName string) (err error) {
-	f, err := os.Open(fileName)
+// LoadFromReaderWithOptions is like LoadFromReader, but reads data with given options,
+// which are also used by ReloadData.
+func LoadFromReaderWithOptions(opts LoadOptions, in io.Reader) (c *ConfigFile, err error) {
+	s := newSnapshot([]string{""}, opts)
+	err = s.read(in, "")
+	return newConfigFile(s), err
+}
+
+func (s *snapshot) loadFile(fileName string) (err error) {
+	data, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return c.read(f)
+	s.fileStats[fileKey(fileName)] = newFileStat(fileName, data)
+	return s.read(bytes.NewReader(data), fileName)
+}
+
+// loadFiles reads files in order with given options and returns a new snapshot.
+func loadFiles(fileNames []string, opts LoadOptions) (*snapshot, error) {
+	s := newSnapshot(fileNames, opts)
+	for _, name := range fileNames {
+		if err := s.loadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	s.base = s
+	return s, nil
 }
 
 // LoadConfigFile reads a file and returns a new configuration representation.
 // This representation can be queried with GetValue.
 func LoadConfigFile(fileName string, moreFiles ...string) (c *ConfigFile, err error) {
+	return LoadConfigFileWithOptions(LoadOptions{}, fileName, moreFiles...)
+}
+
+// LoadConfigFileWithOptions is like LoadConfigFile, but reads files with given options,
+// which are also used by Reload and AppendFiles.
+func LoadConfigFileWithOptions(opts LoadOptions, fileName string, moreFiles ...string) (c *ConfigFile, err error) {
 	// Append files' name together.
 	fileNames := make([]string, 1, len(moreFiles)+1)
 	fileNames[0] = fileName
@@ -224,71 +480,72 @@ func LoadConfigFile(fileName string, moreFiles ...string) (c *ConfigFile, err er
 		fileNames = append(fileNames, moreFiles...)
 	}
 
-	c = newConfigFile(fileNames)
-
-	for _, name := range fileNames {
-		if err = c.loadFile(name); err != nil {
-			return nil, err
-		}
+	s, err := loadFiles(fileNames, opts)
+	if err != nil {
+		return nil, err
 	}
-
-	return c, nil
+	return newConfigFile(s), nil
 }
 
 // Reload reloads configuration file in case it has changes.
+// Readers keep using the old configuration until reloading is done,
+// and it is unchanged if an error occurs.
 func (c *ConfigFile) Reload() (err error) {
-	var cfg *ConfigFile
-	if len(c.fileNames) == 1 {
-		if c.fileNames[0] == "" {
-			return fmt.Errorf("file opened from in-memory data, use ReloadData to reload")
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		if len(s.fileNames) == 1 && s.fileNames[0] == "" {
+			return nil, fmt.Errorf("file opened from in-memory data, use ReloadData to reload")
 		}
-		cfg, err = LoadConfigFile(c.fileNames[0])
-	} else {
-		cfg, err = LoadConfigFile(c.fileNames[0], c.fileNames[1:]...)
-	}
-
-	if err == nil {
-		*c = *cfg
-	}
-	return err
+		return loadFiles(s.fileNames, s.options)
+	})
 }
 
 // ReloadData reloads configuration file from memory
 func (c *ConfigFile) ReloadData(in io.Reader) (err error) {
-	var cfg *ConfigFile
-	if len(c.fileNames) != 1 {
-		return fmt.Errorf("Multiple files loaded, unable to mix in-memory and file data")
-	}
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		if len(s.fileNames) != 1 {
+			return nil, fmt.Errorf("Multiple files loaded, unable to mix in-memory and file data")
+		}
 
-	cfg, err = LoadFromReader(in)
-	if err == nil {
-		*c = *cfg
-	}
-	return err
+		ns := newSnapshot([]string{""}, s.options)
+		if err := ns.read(in, ""); err != nil {
+			return nil, err
+		}
+		return ns, nil
+	})
 }
 
 // AppendFiles appends more files to ConfigFile and reload automatically.
 func (c *ConfigFile) AppendFiles(files ...string) error {
-	if len(c.fileNames) == 1 && c.fileNames[0] == "" {
-		return fmt.Errorf("Cannot append file data to in-memory data")
-	}
-	c.fileNames = append(c.fileNames, files...)
-	return c.Reload()
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		if len(s.fileNames) == 1 && s.fileNames[0] == "" {
+			return nil, fmt.Errorf("Cannot append file data to in-memory data")
+		}
+		fileNames := append(append([]string(nil), s.fileNames...), files...)
+		return loadFiles(fileNames, s.options)
+	})
 }
 
 // ReadError occurs when read configuration file with wrong format.
 type ReadError struct {
 	Reason  ParseError
 	Content string // Line content
+	Position
 }
 
 // Error implement Error interface.
 func (err ReadError) Error() string {
+	var msg string
 	switch err.Reason {
 	case ERR_BLANK_SECTION_NAME:
-		return "empty section name not allowed"
+		msg = "empty section name not allowed"
 	case ERR_COULD_NOT_PARSE:
-		return fmt.Sprintf("could not parse line: %s", string(err.Content))
+		msg = fmt.Sprintf("could not parse line: %s", string(err.Content))
+	default:
+		msg = "invalid read error"
+	}
+
+	if err.Line > 0 {
+		return err.Position.String() + ": " + msg
 	}
-	return "invalid read error"
+	return msg
 }
@@ -0,0 +1,345 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// A snapshot is the state of configuration at some time.
+// Once it is published to readers it must not be changed,
+// writers change a copy of it and publish the copy instead.
+type snapshot struct {
+	fileNames []string                     // Support mutil-files.
+	options   LoadOptions                  // Options to read files.
+	data      map[string]map[string]string // Section -> key : value
+
+	// Lists can keep sections and keys in order.
+	sectionList []string            // Section name list.
+	keyList     map[string][]string // Section -> Key name list
+
+	autoKeys  map[string]map[string]bool // Section -> auto increment keys
+	autoCount map[string]int             // Section -> last number of auto increment keys
+
+	sectionComments map[string]string            // Sections comments.
+	keyComments     map[string]map[string]string // Keys comments.
+	inlineComments  map[string]map[string]string // Keys inline comments.
+
+	doc *document // Original lines of the first source, never changed after read.
+
+	sectionPositions map[string]Position                 // Where sections are declared first.
+	keySources       map[string]map[string][]ValueSource // Where values of keys are read from.
+
+	fileStats map[string]fileStat // States of files when they were loaded or saved.
+	base      *snapshot           // Snapshot when files were loaded or saved.
+
+	owned map[string]bool // Sections not shared with other snapshots, all if nil.
+}
+
+// newSnapshot creates an empty snapshot.
+func newSnapshot(fileNames []string, options LoadOptions) *snapshot {
+	s := new(snapshot)
+	s.fileNames = fileNames
+	s.options = options
+	s.data = make(map[string]map[string]string)
+	s.keyList = make(map[string][]string)
+	s.autoKeys = make(map[string]map[string]bool)
+	s.autoCount = make(map[string]int)
+	s.sectionComments = make(map[string]string)
+	s.keyComments = make(map[string]map[string]string)
+	s.inlineComments = make(map[string]map[string]string)
+	s.sectionPositions = make(map[string]Position)
+	s.keySources = make(map[string]map[string][]ValueSource)
+	s.fileStats = make(map[string]fileStat)
+	return s
+}
+
+// clone returns a copy of snapshot that can be changed.
+// Keys of sections are shared with the original snapshot
+// until they are changed, see own.
+func (s *snapshot) clone() *snapshot {
+	ns := new(snapshot)
+	ns.fileNames = append([]string(nil), s.fileNames...)
+	ns.options = s.options
+	ns.data = maps.Clone(s.data)
+	ns.sectionList = append([]string(nil), s.sectionList...)
+	ns.keyList = maps.Clone(s.keyList)
+	ns.autoKeys = maps.Clone(s.autoKeys)
+	ns.autoCount = maps.Clone(s.autoCount)
+	ns.sectionComments = maps.Clone(s.sectionComments)
+	ns.keyComments = maps.Clone(s.keyComments)
+	ns.inlineComments = maps.Clone(s.inlineComments)
+	ns.doc = s.doc
+	ns.sectionPositions = maps.Clone(s.sectionPositions)
+	ns.keySources = maps.Clone(s.keySources)
+	ns.fileStats = maps.Clone(s.fileStats)
+	ns.base = s.base
+	ns.owned = make(map[string]bool)
+	return ns
+}
+
+// own copies keys of given section that are shared with the snapshot
+// it was cloned from, so they can be changed.
+func (s *snapshot) own(section string) {
+	if s.owned == nil || s.owned[section] {
+		return
+	}
+	s.owned[section] = true
+
+	if keys, ok := s.data[section]; ok {
+		s.data[section] = maps.Clone(keys)
+	}
+	if keys, ok := s.keyList[section]; ok {
+		s.keyList[section] = append([]string(nil), keys...)
+	}
+	if keys, ok := s.autoKeys[section]; ok {
+		s.autoKeys[section] = maps.Clone(keys)
+	}
+	if comments, ok := s.keyComments[section]; ok {
+		s.keyComments[section] = maps.Clone(comments)
+	}
+	if comments, ok := s.inlineComments[section]; ok {
+		s.inlineComments[section] = maps.Clone(comments)
+	}
+	if keys, ok := s.keySources[section]; ok {
+		sources := make(map[string][]ValueSource, len(keys))
+		for key, list := range keys {
+			// Appending to a full slice does not change the shared array.
+			sources[key] = slices.Clip(list)
+		}
+		s.keySources[section] = sources
+	}
+}
+
+// setValue adds a new section-key-value, see ConfigFile.SetValue.
+func (s *snapshot) setValue(section, key, value string) bool {
+	s.own(section)
+	// Check if section exists.
+	if _, ok := s.data[section]; !ok {
+		// Execute add operation.
+		s.data[section] = make(map[string]string)
+		// Append section to list.
+		s.sectionList = append(s.sectionList, section)
+	}
+
+	// Check if key exists.
+	_, ok := s.data[section][key]
+	s.data[section][key] = value
+	if !ok {
+		// If not exists, append to key list.
+		s.keyList[section] = append(s.keyList[section], key)
+	}
+	return !ok
+}
+
+// deleteKey deletes the key in given section, see ConfigFile.DeleteKey.
+func (s *snapshot) deleteKey(section, key string) bool {
+	// Check if section exists.
+	if _, ok := s.data[section]; !ok {
+		return false
+	}
+
+	// Check if key exists.
+	if _, ok := s.data[section][key]; ok {
+		s.own(section)
+		delete(s.data[section], key)
+		delete(s.keySources[section], key)
+		delete(s.autoKeys[section], key)
+		// Remove comments of key.
+		s.setKeyComments(section, key, "")
+		s.setInlineComment(section, key, "")
+		// Get index of key.
+		i := 0
+		for _, keyName := range s.keyList[section] {
+			if keyName == key {
+				break
+			}
+			i++
+		}
+		// Remove from key list.
+		s.keyList[section] = append(s.keyList[section][:i], s.keyList[section][i+1:]...)
+		return true
+	}
+	return false
+}
+
+// deleteSection deletes the entire section, see ConfigFile.DeleteSection.
+func (s *snapshot) deleteSection(section string) bool {
+	// Check if section exists.
+	if _, ok := s.data[section]; !ok {
+		return false
+	}
+
+	delete(s.data, section)
+	delete(s.sectionPositions, section)
+	delete(s.keySources, section)
+	delete(s.autoKeys, section)
+	delete(s.autoCount, section)
+	// Remove comments of section.
+	s.setSectionComments(section, "")
+	delete(s.inlineComments, section)
+	// Get index of section.
+	i := 0
+	for _, secName := range s.sectionList {
+		if secName == section {
+			break
+		}
+		i++
+	}
+	// Remove from section and key list.
+	s.sectionList = append(s.sectionList[:i], s.sectionList[i+1:]...)
+	delete(s.keyList, section)
+	return true
+}
+
+// nextAutoKey returns a new auto increment key of given section,
+// which is "#" followed by the next number.
+func (s *snapshot) nextAutoKey(section string) string {
+	for {
+		s.autoCount[section]++
+		key := "#" + strconv.Itoa(s.autoCount[section])
+		if _, ok := s.data[section][key]; !ok {
+			s.markAutoKey(section, key)
+			return key
+		}
+	}
+}
+
+// markAutoKey marks the key of given section as auto increment.
+func (s *snapshot) markAutoKey(section, key string) {
+	s.own(section)
+	if _, ok := s.autoKeys[section]; !ok {
+		s.autoKeys[section] = make(map[string]bool)
+	}
+	s.autoKeys[section][key] = true
+}
+
+// autoKeyList returns auto increment keys of given section in order.
+func (s *snapshot) autoKeyList(section string) []string {
+	var keys []string
+	for _, key := range s.keyList[section] {
+		if s.autoKeys[section][key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// setSectionComments sets comments of section, see ConfigFile.SetSectionComments.
+func (s *snapshot) setSectionComments(section, comments string) bool {
+	if len(comments) == 0 {
+		if _, ok := s.sectionComments[section]; ok {
+			delete(s.sectionComments, section)
+		}
+
+		// Not exists can be seen as remove.
+		return true
+	}
+
+	// Check if comments exists.
+	_, ok := s.sectionComments[section]
+	if comments[0] != '#' && comments[0] != ';' {
+		comments = "; " + comments
+	}
+	s.sectionComments[section] = comments
+	return !ok
+}
+
+// setKeyComments sets comments of section-key, see ConfigFile.SetKeyComments.
+func (s *snapshot) setKeyComments(section, key, comments string) bool {
+	s.own(section)
+	// Check if section exists.
+	if _, ok := s.keyComments[section]; ok {
+		if len(comments) == 0 {
+			if _, ok := s.keyComments[section][key]; ok {
+				delete(s.keyComments[section], key)
+			}
+
+			// Not exists can be seen as remove.
+			return true
+		}
+	} else {
+		if len(comments) == 0 {
+			// Not exists can be seen as remove.
+			return true
+		} else {
+			// Execute add operation.
+			s.keyComments[section] = make(map[string]string)
+		}
+	}
+
+	// Check if key exists.
+	_, ok := s.keyComments[section][key]
+	if comments[0] != '#' && comments[0] != ';' {
+		comments = "; " + comments
+	}
+	s.keyComments[section][key] = comments
+	return !ok
+}
+
+// getKeyComments returns the comments of key in the given section.
+func (s *snapshot) getKeyComments(section, key string) string {
+	if _, ok := s.keyComments[section]; ok {
+		return s.keyComments[section][key]
+	}
+	return ""
+}
+
+// setInlineComment sets inline comment of section-key,
+// see ConfigFile.SetKeyInlineComment.
+func (s *snapshot) setInlineComment(section, key, comment string) bool {
+	s.own(section)
+	if len(comment) == 0 {
+		// Not exists can be seen as remove.
+		delete(s.inlineComments[section], key)
+		return true
+	}
+
+	if _, ok := s.inlineComments[section]; !ok {
+		s.inlineComments[section] = make(map[string]string)
+	}
+	// Check if key exists.
+	_, ok := s.inlineComments[section][key]
+	if comment[0] != '#' && comment[0] != ';' {
+		comment = "; " + comment
+	}
+	s.inlineComments[section][key] = comment
+	return !ok
+}
+
+// getInlineComment returns the inline comment of key in the given section.
+func (s *snapshot) getInlineComment(section, key string) string {
+	return s.inlineComments[section][key]
+}
+
+// deleteKeySource forgets where the value of given section-key is read from,
+// because it is set by program.
+func (s *snapshot) deleteKeySource(section, key string) {
+	if _, ok := s.keySources[section][key]; ok {
+		s.own(section)
+		delete(s.keySources[section], key)
+	}
+}
+
+// addKeySource records a value of given section-key and where it is read from.
+func (s *snapshot) addKeySource(section, key string, source ValueSource) {
+	s.own(section)
+	if _, ok := s.keySources[section]; !ok {
+		s.keySources[section] = make(map[string][]ValueSource)
+	}
+	s.keySources[section][key] = append(s.keySources[section][key], source)
+}
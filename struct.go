@@ -0,0 +1,428 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isTextType returns true if values of type are converted from and to text
+// as a whole, instead of being nested sections or lists.
+func isTextType(typ reflect.Type) bool {
+	return typ == timeType || typ.Implements(textMarshalerType) ||
+		reflect.PointerTo(typ).Implements(textUnmarshalerType)
+}
+
+// isSectionType returns true if values of type are nested sections,
+// which are structs or pointers to structs that are not text types.
+func isSectionType(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr && !isTextType(typ) {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Struct && !isTextType(typ)
+}
+
+// MapTo maps the configuration to given struct pointer.
+// Fields with basic types are read from the DEFAULT section,
+// nested structs are read from the section with the same name,
+// and structs nested in them from "parent.child" sub-sections.
+// Nil pointers to structs are allocated only if their sections exist,
+// and nil pointers are skipped by ReflectFrom.
+// The name of section or key can be changed by tag `ini:"name"`,
+// and a field is skipped with tag `ini:"-"`.
+// Slices are split by tag `delim:","`, which is also the default delimiter,
+// and elements can be quoted to contain it in the same way as Strings.
+// Types implementing encoding.TextUnmarshaler are converted by it.
+// Keys that do not exist leave the fields untouched.
+// It returns a MapError with all the values that could not be converted.
+func (c *ConfigFile) MapTo(v interface{}) error {
+	return c.MapToSection(DEFAULT_SECTION, v)
+}
+
+// MapToSection maps the given section to given struct pointer,
+// see MapTo for the rules. Nested structs are read from sub-sections
+// of the given section.
+func (c *ConfigFile) MapToSection(section string, v interface{}) error {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return errors.New("MapTo: value must be a non-nil pointer to struct")
+	}
+
+	var errs MapError
+	c.mapToStruct(c.load(), val.Elem(), section, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// mapToStruct fills all the fields of struct from given section,
+// and returns true if any of the keys or sub-sections exists.
+func (c *ConfigFile) mapToStruct(s *snapshot, val reflect.Value, section string, errs *MapError) bool {
+	found := false
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := val.Field(i)
+		tpField := typ.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		name, ok := fieldName(tpField)
+		if !ok {
+			continue
+		}
+
+		if isSectionType(field.Type()) {
+			// Embedded struct shares the same section.
+			child := section
+			if !tpField.Anonymous {
+				child = subSection(section, name)
+			}
+			_, exist := s.data[child]
+			exist = exist && !tpField.Anonymous
+
+			if field.Kind() != reflect.Ptr {
+				exist = c.mapToStruct(s, field, child, errs) || exist
+			} else if !field.IsNil() {
+				exist = c.mapToStruct(s, field.Elem(), child, errs) || exist
+			} else {
+				// Nil pointer is only set when there is something to map.
+				ptr := reflect.New(field.Type().Elem())
+				if exist = c.mapToStruct(s, ptr.Elem(), child, errs) || exist; exist {
+					field.Set(ptr)
+				}
+			}
+			found = found || exist
+			continue
+		}
+
+		value, err := c.getValue(s, section, name)
+		if _, ok := err.(GetError); ok {
+			// Missing section or key is not an error.
+			continue
+		}
+		found = true
+		if err != nil {
+			value, _, _ = c.rawValue(s, section, name)
+			*errs = append(*errs, ValueError{section, name, value, err})
+			continue
+		}
+		if err = c.setWithProperType(field, value, tpField.Tag.Get("delim")); err != nil {
+			*errs = append(*errs, ValueError{section, name, value, err})
+		}
+	}
+	return found
+}
+
+// setWithProperType converts value to the type of field and sets it.
+func (c *ConfigFile) setWithProperType(field reflect.Value, value, delim string) error {
+	if field.Type() == timeType {
+		t, err := c.parseTime(value)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := c.setWithProperType(ptr.Elem(), value, delim); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	if field.CanAddr() && field.Addr().CanInterface() {
+		if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(value))
+		}
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := c.parseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Type() == durationType {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(d))
+			return nil
+		}
+		i, err := parseIntBits(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := parseUintBits(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Complex64, reflect.Complex128:
+		c, err := strconv.ParseComplex(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetComplex(c)
+	case reflect.Slice:
+		vals, err := splitList(value, delim)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+		for i := range vals {
+			if err := c.setWithProperType(slice.Index(i), vals[i], delim); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("unsupported type '%s'", field.Type())
+	}
+	return nil
+}
+
+// ReflectFrom sets sections and keys of configuration from given struct
+// or pointer to struct, which is the reverse of MapTo and follows the same rules.
+// Sections are created in field order so saved output is stable.
+// If withComments is true, tag `comment:"..."` of fields is set
+// as comments of corresponding sections and keys.
+func (c *ConfigFile) ReflectFrom(v interface{}, withComments ...bool) error {
+	return c.ReflectFromSection(DEFAULT_SECTION, v, withComments...)
+}
+
+// ReflectFromSection sets keys of given section from given struct
+// or pointer to struct, see ReflectFrom for the rules.
+// Nested structs are written to sub-sections of the given section.
+func (c *ConfigFile) ReflectFromSection(section string, v interface{}, withComments ...bool) error {
+	// Blank section name represents DEFAULT section.
+	if len(section) == 0 {
+		section = DEFAULT_SECTION
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return errors.New("ReflectFrom: value must be a struct or non-nil pointer to struct")
+	}
+
+	// All the fields are set at once, or none if an error occurs.
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		ns := s.clone()
+		if err := c.reflectFromStruct(ns, val, section, len(withComments) > 0 && withComments[0]); err != nil {
+			return nil, err
+		}
+		return ns, nil
+	})
+}
+
+// reflectFromStruct sets all the fields of struct to given section.
+func (c *ConfigFile) reflectFromStruct(s *snapshot, val reflect.Value, section string, withComments bool) error {
+	// Make section exist even though it does not have any key.
+	if section != DEFAULT_SECTION {
+		s.setValue(section, " ", " ")
+	}
+
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := val.Field(i)
+		tpField := typ.Field(i)
+		if len(tpField.PkgPath) > 0 && !tpField.Anonymous {
+			// Unexported field.
+			continue
+		}
+
+		name, ok := fieldName(tpField)
+		if !ok {
+			continue
+		}
+		comments := tpField.Tag.Get("comment")
+
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				// Nil pointer has nothing to write.
+				continue
+			}
+			if isSectionType(field.Type()) {
+				field = field.Elem()
+			}
+		}
+		if isSectionType(field.Type()) {
+			// Embedded struct shares the same section.
+			if tpField.Anonymous {
+				if err := c.reflectFromStruct(s, field, section, withComments); err != nil {
+					return err
+				}
+				continue
+			}
+
+			child := subSection(section, name)
+			if err := c.reflectFromStruct(s, field, child, withComments); err != nil {
+				return err
+			}
+			if withComments && len(comments) > 0 {
+				s.setSectionComments(child, comments)
+			}
+			continue
+		}
+
+		value, err := c.formatWithProperType(field, tpField.Tag.Get("delim"))
+		if err != nil {
+			return ValueError{section, name, fmt.Sprint(field.Interface()), err}
+		}
+		s.setValue(section, name, value)
+		s.deleteKeySource(section, name)
+		if withComments && len(comments) > 0 {
+			s.setKeyComments(section, name, comments)
+		}
+	}
+	return nil
+}
+
+// formatWithProperType converts value of field to string,
+// time.Time is formatted by the first layout set by SetTimeLayouts.
+func (c *ConfigFile) formatWithProperType(field reflect.Value, delim string) (string, error) {
+	if field.Type() == timeType && field.CanInterface() {
+		return field.Interface().(time.Time).Format(c.layouts()[0]), nil
+	}
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "", nil
+		}
+		return c.formatWithProperType(field.Elem(), delim)
+	}
+	if field.CanInterface() && field.Type().Implements(textMarshalerType) {
+		text, err := field.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Type() == durationType {
+			return time.Duration(field.Int()).String(), nil
+		}
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits()), nil
+	case reflect.Complex64, reflect.Complex128:
+		return strconv.FormatComplex(field.Complex(), 'g', -1, field.Type().Bits()), nil
+	case reflect.Slice:
+		vals := make([]string, field.Len())
+		for i := range vals {
+			val, err := c.formatWithProperType(field.Index(i), delim)
+			if err != nil {
+				return "", fmt.Errorf("element %d: %v", i, err)
+			}
+			vals[i] = val
+		}
+		return joinList(vals, delim), nil
+	}
+	return "", fmt.Errorf("unsupported type '%s'", field.Type())
+}
+
+// fieldName returns the section or key name of struct field,
+// and false if the field should be skipped.
+func fieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("ini")
+	if tag == "-" {
+		return "", false
+	}
+	if len(tag) > 0 {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+// subSection returns the name of child section,
+// DEFAULT section has no parent of others.
+func subSection(parent, child string) string {
+	if parent == DEFAULT_SECTION {
+		return child
+	}
+	return parent + "." + child
+}
+
+// ValueError occurs when a value cannot be converted to the wanted type.
+type ValueError struct {
+	Section string
+	Key     string
+	Value   string
+	Err     error
+}
+
+// Error implements Error interface.
+func (err ValueError) Error() string {
+	return fmt.Sprintf("section '%s' key '%s': cannot convert value '%s': %v",
+		err.Section, err.Key, err.Value, err.Err)
+}
+
+// Unwrap returns the underlying conversion error.
+func (err ValueError) Unwrap() error {
+	return err.Err
+}
+
+// MapError occurs when one or more values cannot be mapped to struct fields.
+type MapError []ValueError
+
+// Error implements Error interface.
+func (err MapError) Error() string {
+	msgs := make([]string, len(err))
+	for i := range err {
+		msgs[i] = err[i].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
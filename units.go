@@ -0,0 +1,226 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Units of byte size in lower case, SI units are powers of 1000
+// and IEC units are powers of 1024.
+var byteUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"eb":  1e18,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+	"eib": 1 << 60,
+}
+
+// parseByteSize parses byte size like "512", "10MB", "1.5 GiB" or "-1KiB".
+// Units are case-insensitive.
+func parseByteSize(value string) (int64, error) {
+	s := strings.TrimSpace(value)
+	neg := strings.HasPrefix(s, "-")
+	if neg || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	num, unitName := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	unit, ok := byteUnits[unitName]
+	if len(num) == 0 || !ok {
+		return 0, fmt.Errorf("invalid byte size '%s'", value)
+	}
+
+	if strings.Contains(num, ".") {
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid byte size '%s'", value)
+		}
+		f = math.Round(f * float64(unit))
+		if neg {
+			f = -f
+		}
+		if f >= math.MaxInt64 || f < math.MinInt64 {
+			return 0, fmt.Errorf("byte size '%s' out of range", value)
+		}
+		return int64(f), nil
+	}
+
+	// Magnitude of the minimum is one more than the maximum.
+	limit := uint64(math.MaxInt64)
+	if neg {
+		limit++
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > limit/uint64(unit) {
+		return 0, fmt.Errorf("byte size '%s' out of range", value)
+	}
+	if neg {
+		return -int64(n * uint64(unit)), nil
+	}
+	return int64(n * uint64(unit)), nil
+}
+
+// formatByteSize formats byte size in the largest unit it is a multiple of,
+// IEC units are preferred to SI units of the same power.
+func formatByteSize(size int64) string {
+	if size == 0 {
+		return "0B"
+	}
+	units := []string{"E", "P", "T", "G", "M", "K"}
+	for i, prefix := range units {
+		if iec := byteUnits[strings.ToLower(prefix)+"ib"]; size%iec == 0 {
+			return strconv.FormatInt(size/iec, 10) + prefix + "iB"
+		}
+		// SI prefix of kilo is lower case.
+		if i == len(units)-1 {
+			prefix = "k"
+		}
+		if si := byteUnits[strings.ToLower(prefix)+"b"]; size%si == 0 {
+			return strconv.FormatInt(size/si, 10) + prefix + "B"
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
+
+// SetTimeLayouts sets layouts that Time tries in order to parse values,
+// and the first one is used by SetTime to format values.
+// It is time.RFC3339 by default.
+// It should be called before the configuration is used by multiple goroutines.
+func (c *ConfigFile) SetTimeLayouts(layouts ...string) {
+	c.timeLayouts = layouts
+}
+
+// layouts returns layouts to parse time values.
+func (c *ConfigFile) layouts() []string {
+	if len(c.timeLayouts) == 0 {
+		return []string{time.RFC3339}
+	}
+	return c.timeLayouts
+}
+
+// Duration returns time.Duration type value, e.g. "30s" or "1h30m".
+func (c *ConfigFile) Duration(section, key string) (time.Duration, error) {
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(value)
+}
+
+// ByteSize returns number of bytes of value like "512", "10MB" or "4KiB"
+// with optional sign,
+// in SI units (kB, MB, GB, ...) which are powers of 1000,
+// or IEC units (KiB, MiB, GiB, ...) which are powers of 1024.
+func (c *ConfigFile) ByteSize(section, key string) (int64, error) {
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return 0, err
+	}
+	return parseByteSize(value)
+}
+
+// Time returns time.Time type value parsed by layouts set by SetTimeLayouts.
+// It returns the error of the first layout if none of them matches.
+func (c *ConfigFile) Time(section, key string) (time.Time, error) {
+	value, err := c.GetValue(section, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.parseTime(value)
+}
+
+// parseTime parses value by layouts set by SetTimeLayouts.
+func (c *ConfigFile) parseTime(value string) (time.Time, error) {
+	var firstErr error
+	for _, layout := range c.layouts() {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return time.Time{}, firstErr
+}
+
+// MustDuration always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustDuration(section, key string, defaultVal ...time.Duration) time.Duration {
+	value, err := c.Duration(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustByteSize always returns value without error,
+// it returns 0 if error occurs.
+func (c *ConfigFile) MustByteSize(section, key string, defaultVal ...int64) int64 {
+	value, err := c.ByteSize(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// MustTime always returns value without error,
+// it returns zero time if error occurs.
+func (c *ConfigFile) MustTime(section, key string, defaultVal ...time.Time) time.Time {
+	value, err := c.Time(section, key)
+	if len(defaultVal) > 0 && err != nil {
+		return defaultVal[0]
+	}
+	return value
+}
+
+// SetDuration sets time.Duration type value in form of "1h30m0s".
+// It returns true if the key and value were inserted.
+func (c *ConfigFile) SetDuration(section, key string, d time.Duration) bool {
+	return c.SetValue(section, key, formatDuration(d))
+}
+
+// SetByteSize sets number of bytes in the largest unit it is a multiple of,
+// e.g. "10MB" or "4KiB".
+// It returns true if the key and value were inserted.
+func (c *ConfigFile) SetByteSize(section, key string, size int64) bool {
+	return c.SetValue(section, key, formatByteSize(size))
+}
+
+// SetTime sets time.Time type value formatted by the first layout
+// set by SetTimeLayouts.
+// It returns true if the key and value were inserted.
+func (c *ConfigFile) SetTime(section, key string, t time.Time) bool {
+	return c.SetValue(section, key, t.Format(c.layouts()[0]))
+}
@@ -14,6 +14,11 @@
 
 package goconfig
 
+import (
+	"strconv"
+	"strings"
+)
+
 // deepCopy will copy a new map with different address
 func deepCopy(d map[string]string) map[string]string {
 	rs := make(map[string]string)
@@ -23,3 +28,62 @@ func deepCopy(d map[string]string) map[string]string {
 
 	return rs
 }
+
+// intBase returns integer value to be parsed and its base.
+// Base is 0 to accept prefixes 0x, 0o, 0b and underscores if value has
+// any of the prefixes, otherwise underscores between digits are removed
+// and base is 10, so numbers with leading zeros are still decimal.
+func intBase(value string) (string, int) {
+	s := strings.ToLower(strings.TrimLeft(value, "+-"))
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0o") || strings.HasPrefix(s, "0b") {
+		return value, 0
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] == '_' && (i == 0 || i == len(value)-1 || !isDigit(value[i-1]) || !isDigit(value[i+1])) {
+			// Leave misplaced underscores to fail parsing.
+			return value, 10
+		}
+	}
+	return strings.ReplaceAll(value, "_", ""), 10
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func parseInt(s string) (int, error) {
+	i, err := parseIntBits(s, strconv.IntSize)
+	return int(i), err
+}
+
+func parseInt64(s string) (int64, error) { return parseIntBits(s, 64) }
+
+// parseIntBits parses signed integer of given bit size, see intBase.
+func parseIntBits(s string, bitSize int) (int64, error) {
+	v, base := intBase(s)
+	i, err := strconv.ParseInt(v, base, bitSize)
+	if ne, ok := err.(*strconv.NumError); ok {
+		// Report the value as it is written.
+		ne.Num = s
+	}
+	return i, err
+}
+
+func parseUint(s string) (uint, error) {
+	u, err := parseUintBits(s, strconv.IntSize)
+	return uint(u), err
+}
+
+func parseUint64(s string) (uint64, error) { return parseUintBits(s, 64) }
+
+// parseUintBits parses unsigned integer of given bit size, see intBase.
+// A leading plus sign is accepted as parseIntBits does.
+func parseUintBits(s string, bitSize int) (uint64, error) {
+	v, base := intBase(s)
+	u, err := strconv.ParseUint(strings.TrimPrefix(v, "+"), base, bitSize)
+	if ne, ok := err.(*strconv.NumError); ok {
+		// Report the value as it is written.
+		ne.Num = s
+	}
+	return u, err
+}
+
+func parseFloat64(s string) (float64, error) { return strconv.ParseFloat(s, 64) }
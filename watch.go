@@ -0,0 +1,210 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package goconfig
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Change describes a section or key that has been changed by reloading.
+type Change struct {
+	Section string
+	Key     string // Empty if the whole section was added or deleted.
+}
+
+// A Watcher polls files of a configuration and reloads it when they change.
+type Watcher struct {
+	c        *ConfigFile
+	interval time.Duration
+
+	lock          sync.Mutex
+	changeHandles []func([]Change)
+	errorHandles  []func(error)
+}
+
+// NewWatcher returns a Watcher that polls files of the configuration
+// in given interval. Polling works on every system without
+// depending on OS-specific notification APIs.
+func (c *ConfigFile) NewWatcher(interval time.Duration) *Watcher {
+	return &Watcher{c: c, interval: interval}
+}
+
+// OnChange registers a function to be called with changed sections and keys
+// after the configuration is reloaded.
+func (w *Watcher) OnChange(fn func(changes []Change)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.changeHandles = append(w.changeHandles, fn)
+}
+
+// OnError registers a function to be called when reloading fails,
+// the configuration is kept unchanged in that case.
+func (w *Watcher) OnError(fn func(err error)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.errorHandles = append(w.errorHandles, fn)
+}
+
+// fileState is the state of a file when it was polled.
+type fileState struct {
+	exist   bool
+	size    int64
+	modTime time.Time
+}
+
+// pollFiles returns states of all files of configuration.
+func (w *Watcher) pollFiles() []fileState {
+	fileNames := w.c.load().fileNames
+	states := make([]fileState, len(fileNames))
+	for i, name := range fileNames {
+		fi, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		states[i] = fileState{true, fi.Size(), fi.ModTime()}
+	}
+	return states
+}
+
+func sameStates(a, b []fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].exist != b[i].exist || a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run polls files until the context is done.
+// Files are reloaded once they have stopped changing for an interval,
+// so a file being written is not read halfway, and reloading is retried
+// until it succeeds.
+// It returns an error if the interval is not positive
+// or the configuration was loaded from memory.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.interval <= 0 {
+		return fmt.Errorf("non-positive interval %s for watcher", w.interval)
+	}
+	if fileNames := w.c.load().fileNames; len(fileNames) == 1 && fileNames[0] == "" {
+		return fmt.Errorf("file opened from in-memory data, cannot be watched")
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	loaded := w.pollFiles()
+	var pending []fileState // States of files that changed last time.
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		states := w.pollFiles()
+		if sameStates(states, loaded) {
+			pending = nil
+			continue
+		}
+		// Wait until files have stopped changing.
+		if pending == nil || !sameStates(states, pending) {
+			pending = states
+			continue
+		}
+
+		pending = nil
+		if w.reload() {
+			loaded = states
+		}
+	}
+}
+
+// reload reloads the configuration and calls registered functions,
+// it returns false if reloading fails.
+// Functions are called without lock, so they can register more functions.
+func (w *Watcher) reload() bool {
+	old := w.c.load()
+	err := w.c.Reload()
+
+	// Functions are only appended, so the copied lists are not changed.
+	w.lock.Lock()
+	changeHandles, errorHandles := w.changeHandles, w.errorHandles
+	w.lock.Unlock()
+
+	if err != nil {
+		for _, fn := range errorHandles {
+			fn(err)
+		}
+		return false
+	}
+
+	changes := diffValues(old.data, w.c.load().data)
+	if len(changes) == 0 {
+		return true
+	}
+	for _, fn := range changeHandles {
+		fn(changes)
+	}
+	return true
+}
+
+// diffValues returns sections and keys that are different between old and new,
+// sorted by section and key.
+func diffValues(old, new map[string]map[string]string) []Change {
+	var changes []Change
+	for section, keys := range new {
+		oldKeys, ok := old[section]
+		if !ok {
+			changes = append(changes, Change{Section: section})
+			continue
+		}
+		for key, value := range keys {
+			if oldValue, ok := oldKeys[key]; !ok || oldValue != value {
+				changes = append(changes, Change{section, key})
+			}
+		}
+		for key := range oldKeys {
+			if _, ok := keys[key]; !ok {
+				changes = append(changes, Change{section, key})
+			}
+		}
+	}
+	for section := range old {
+		if _, ok := new[section]; !ok {
+			changes = append(changes, Change{Section: section})
+		}
+	}
+
+	sortChanges(changes)
+	return changes
+}
+
+// sortChanges sorts changes by section and key.
+func sortChanges(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Section != changes[j].Section {
+			return changes[i].Section < changes[j].Section
+		}
+		return changes[i].Key < changes[j].Key
+	})
+}
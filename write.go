@@ -16,98 +16,483 @@ package goconfig
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
-// SaveConfigData writes configuration to a writer
-func SaveConfigData(c *ConfigFile, out io.Writer) (err error) {
-	equalSign := "="
-	if c.prettyFormat {
-		equalSign = " = "
+// configWriter writes configuration based on the original document,
+// so that lines have not been changed are kept as they were.
+type configWriter struct {
+	s         *snapshot
+	buf       *bytes.Buffer
+	equalSign string
+	opts      LoadOptions // Options the output will be read with.
+	lineBreak string      // Line break of new lines.
+	blank     bool        // Whether the last line is blank.
+	err       error       // First key or value that cannot be written.
+}
+
+// writeRaw writes original bytes of a line.
+func (w *configWriter) writeRaw(l *docLine) {
+	w.buf.WriteString(l.raw)
+	w.blank = l.kind == lineBlank
+}
+
+// writeLine writes a new line, and makes sure it starts on its own line.
+// Line breaks in it, e.g. of comments, are the same as the others.
+func (w *configWriter) writeLine(line string) {
+	if w.buf.Len() > 0 && w.buf.Bytes()[w.buf.Len()-1] != '\n' {
+		w.buf.WriteString(w.lineBreak)
 	}
+	line = strings.Replace(strings.Replace(line, "\r\n", "\n", -1), "\n", w.lineBreak, -1)
+	w.buf.WriteString(line + w.lineBreak)
+	w.blank = len(line) == 0
+}
 
-	buf := bytes.NewBuffer(nil)
-	for _, section := range c.sectionList {
-		// Write section comments.
-		if len(c.GetSectionComments(section)) > 0 {
-			if _, err = buf.WriteString(c.GetSectionComments(section) + LineBreak); err != nil {
-				return err
-			}
+// formatValue returns the value of section-key to be written,
+// see formatValue, and records the error if it cannot be written.
+func (w *configWriter) formatValue(section, key, quote string) string {
+	value, ok := formatValue(w.s.data[section][key], quote, w.opts)
+	if !ok && w.err == nil {
+		w.err = fmt.Errorf("section '%s' key '%s': value cannot be written without escape sequences", section, key)
+	}
+	return strings.Replace(value, "\n", w.lineBreak, -1)
+}
+
+// writeKey writes a key which does not exist in the original document.
+func (w *configWriter) writeKey(section, key string) {
+	// Write key comments.
+	if comments := w.s.getKeyComments(section, key); len(comments) > 0 {
+		w.writeLine(comments)
+	}
+
+	keyName, ok := "-", true
+	if !w.s.autoKeys[section][key] {
+		keyName, ok = formatKey(key, w.opts)
+	}
+	if !ok && w.err == nil {
+		w.err = fmt.Errorf("section '%s' key '%s': key cannot be written", section, key)
+	}
+	line := keyName + w.equalSign + w.formatValue(section, key, "")
+	if inline := w.s.getInlineComment(section, key); len(inline) > 0 {
+		line += " " + inline
+	}
+	w.writeLine(line)
+}
+
+// writeSection writes a section which does not exist in the original document.
+func (w *configWriter) writeSection(section string) {
+	// Put a line between sections.
+	if w.buf.Len() > 0 && !w.blank {
+		w.writeLine("")
+	}
+
+	// Write section comments.
+	if comments := w.s.sectionComments[section]; len(comments) > 0 {
+		w.writeLine(comments)
+	}
+	if section != DEFAULT_SECTION {
+		// Write section name.
+		w.writeLine("[" + section + "]")
+	}
+	for _, key := range w.s.keyList[section] {
+		if key != " " {
+			w.writeKey(section, key)
 		}
+	}
+	w.writeLine("")
+}
 
-		if section != DEFAULT_SECTION {
-			// Write section name.
-			if _, err = buf.WriteString("[" + section + "]" + LineBreak); err != nil {
-				return err
-			}
+// writeDocument writes the original document with changes applied,
+// and then sections that do not exist in it.
+func (w *configWriter) writeDocument(doc *document) {
+	s := w.s
+	if doc.bom {
+		w.buf.Write([]byte{239, 187, 191})
+	}
+
+	liveKeys, headers := doc.liveLines()
+	anchors := doc.anchors()
+	// Section has been deleted or not.
+	deleted := func(section string) bool {
+		_, ok := s.data[section]
+		return !ok
+	}
+	// Line has been deleted or not.
+	dropped := func(l *docLine) bool {
+		if l.kind == lineKey {
+			_, ok := s.data[l.section][l.key]
+			return !ok
+		}
+		return deleted(l.section)
+	}
+	// Comments of line have been changed or not.
+	commentsChanged := func(l *docLine) bool {
+		switch l.kind {
+		case lineSection:
+			return headers[l.section] == l && l.comments != s.sectionComments[l.section]
+		case lineKey:
+			return liveKeys[l.section][l.key] == l && l.comments != s.getKeyComments(l.section, l.key)
 		}
+		return false
+	}
 
-		for _, key := range c.keyList[section] {
-			if key != " " {
-				// Write key comments.
-				if len(c.GetKeyComments(section, key)) > 0 {
-					if _, err = buf.WriteString(c.GetKeyComments(section, key) + LineBreak); err != nil {
-						return err
-					}
-				}
+	// New DEFAULT section has no header, so it must be at the beginning.
+	if _, ok := anchors[DEFAULT_SECTION]; !ok && !deleted(DEFAULT_SECTION) {
+		w.writeSection(DEFAULT_SECTION)
+	}
 
-				keyName := key
-				// Check if it's auto increment.
-				if keyName[0] == '#' {
-					keyName = "-"
+	for _, l := range doc.lines {
+		switch l.kind {
+		case lineBlank, lineComment:
+			if l.owner != nil {
+				if dropped(l.owner) || commentsChanged(l.owner) {
+					continue
 				}
-				//[SWH|+]:支持键名包含等号和冒号
-				if strings.Contains(keyName, `=`) || strings.Contains(keyName, `:`) {
-					if strings.Contains(keyName, "`") {
-						if strings.Contains(keyName, `"`) {
-							keyName = `"""` + keyName + `"""`
-						} else {
-							keyName = `"` + keyName + `"`
-						}
-					} else {
-						keyName = "`" + keyName + "`"
-					}
+			} else if doc.sections[l.section] && deleted(l.section) {
+				continue
+			}
+			w.writeRaw(l)
+		case lineSection:
+			if dropped(l) {
+				continue
+			}
+			if commentsChanged(l) {
+				if comments := s.sectionComments[l.section]; len(comments) > 0 {
+					w.writeLine(comments)
 				}
-				value := c.data[section][key]
-				// In case key value contains "`" or "\"".
-				if strings.Contains(value, "`") {
-					if strings.Contains(value, `"`) {
-						value = `"""` + value + `"""`
-					} else {
-						value = `"` + value + `"`
-					}
+			}
+			w.writeRaw(l)
+		case lineKey:
+			if dropped(l) {
+				break
+			}
+			if commentsChanged(l) {
+				if comments := s.getKeyComments(l.section, l.key); len(comments) > 0 {
+					w.writeLine(comments)
 				}
+			}
+			value := s.data[l.section][l.key]
+			inline := s.getInlineComment(l.section, l.key)
+			if liveKeys[l.section][l.key] == l && (value != l.value || inline != l.inline) {
+				suffix := formatInlineComment(l, inline)
+				if value == l.value {
+					// Keep the original form of value.
+					w.buf.WriteString(l.raw[:len(l.raw)-len(l.suffix)] + suffix)
+				} else if len(l.indent) > 0 && canContinue(value, w.opts) {
+					w.buf.WriteString(l.prefix + formatContinuation(l, value, w.lineBreak) + suffix)
+				} else {
+					w.buf.WriteString(l.prefix + w.formatValue(l.section, l.key, l.quote) + suffix)
+				}
+				w.blank = false
+			} else {
+				w.writeRaw(l)
+			}
+		}
 
-				// Write key and value.
-				if _, err = buf.WriteString(keyName + equalSign + value + LineBreak); err != nil {
-					return err
+		// Write new keys after the last key of section.
+		if anchors[l.section] == l && !deleted(l.section) {
+			for _, key := range s.keyList[l.section] {
+				if _, ok := liveKeys[l.section][key]; !ok && key != " " {
+					w.writeKey(l.section, key)
 				}
 			}
 		}
+	}
 
-		// Put a line between sections.
-		if _, err = buf.WriteString(LineBreak); err != nil {
-			return err
+	for _, section := range s.sectionList {
+		if _, ok := anchors[section]; !ok && section != DEFAULT_SECTION {
+			w.writeSection(section)
 		}
 	}
+}
+
+// formatKey returns the key name to be written with a quote
+// that it can be read back as it is with given options,
+// or false if there is none.
+func formatKey(key string, opts LoadOptions) (string, bool) {
+	if opts.EscapeSequences && needEscape(key) {
+		return strconv.Quote(key), true
+	}
+	if strings.Contains(key, "\n") {
+		return "", false
+	}
 
-	if _, err := buf.WriteTo(out); err != nil {
+	//[SWH|+]:支持键名包含等号和冒号
+	for _, quote := range []string{"", "`", `"`, `"""`} {
+		keyName := quote + key + quote
+		if keyName[0] == '#' || keyName[0] == ';' || keyName[0] == '[' {
+			// Comment or section.
+			continue
+		}
+		if k, quoted, i := parseKey(keyName+"=", opts); i == len(keyName) && k == key && (quoted || k != "-") {
+			return keyName, true
+		}
+	}
+	if opts.EscapeSequences {
+		return strconv.Quote(key), true
+	}
+	return "", false
+}
+
+// formatValue returns the value to be written with a quote
+// that it can be read back as it is with given options,
+// or false if there is none. It keeps the given quote if the value
+// can still use it. Multi-line value is written in triple quotes
+// if LoadOptions.MultiLineValues is set,
+// and values need escape sequences are written in double quotes if they are allowed.
+func formatValue(value, quote string, opts LoadOptions) (string, bool) {
+	if opts.EscapeSequences && (quote == `"` || needEscape(value)) {
+		return strconv.Quote(value), true
+	}
+
+	if strings.Contains(value, "\n") {
+		// Line breaks are read as "\n", and the value ends at the first triple quotes.
+		if !opts.MultiLineValues || strings.Contains(value, `"""`) || strings.HasSuffix(value, `"`) ||
+			strings.Contains(value, "\r\n") {
+			return "", false
+		}
+		return `"""` + value + `"""`, true
+	}
+
+	for _, q := range []string{quote, "", "`", `"""`} {
+		text := q + value + q
+		if v, _, n := parseValue(text, opts); n == len(text) && v == value && text == strings.TrimSpace(text) {
+			return text, true
+		}
+	}
+	if opts.EscapeSequences {
+		return strconv.Quote(value), true
+	}
+	return "", false
+}
+
+// needEscape returns true if the value cannot be read back as it is
+// without quoting.
+func needEscape(value string) bool {
+	if value != strings.TrimSpace(value) || isEscapeQuoted(value) {
+		return true
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// canContinue returns true if the value can be written in continuation lines
+// and read back as it is.
+func canContinue(value string, opts LoadOptions) bool {
+	for i, line := range strings.Split(value, "\n") {
+		if len(line) == 0 || line != strings.TrimSpace(line) {
+			return false
+		}
+		if i == 0 {
+			// First line is parsed as a single-line value.
+			if v, quote, n := parseValue(line, opts); v != line || quote != "" || n != len(line) {
+				return false
+			}
+		}
+		// Comments end continuation.
+		if i > 0 && (line[0] == '#' || line[0] == ';') {
+			return false
+		}
+	}
+	return true
+}
+
+// formatContinuation returns the value to be written in continuation lines
+// with the indentation of given line, the first line of value is written
+// on the key line unless it was empty there.
+func formatContinuation(l *docLine, value, lineBreak string) string {
+	value = strings.Replace(value, "\n", lineBreak+l.indent, -1)
+	if rest := strings.TrimLeft(l.raw[len(l.prefix):], " \t"); rest[0] == '\r' || rest[0] == '\n' {
+		return lineBreak + l.indent + value
+	}
+	return value
+}
+
+// formatInlineComment returns the suffix of given line
+// with its inline comment replaced by the given one.
+func formatInlineComment(l *docLine, inline string) string {
+	if inline == l.inline {
+		return l.suffix
+	}
+	if len(l.inline) == 0 {
+		return " " + inline + l.suffix
+	}
+
+	i := strings.Index(l.suffix, l.inline)
+	if len(inline) == 0 {
+		return strings.TrimRight(l.suffix[:i], " \t") + l.suffix[i+len(l.inline):]
+	}
+	return l.suffix[:i] + inline + l.suffix[i+len(l.inline):]
+}
+
+// SaveConfigData writes configuration to a writer.
+// Lines that have not been changed since the configuration was read
+// are written byte-identical, including blank lines and comments,
+// and new sections and keys are appended.
+// Keys and values are quoted so that they are read back as they are,
+// it returns an error if there is no such quote for one of them,
+// which never happens when LoadOptions.EscapeSequences is set.
+func SaveConfigData(c *ConfigFile, out io.Writer) (err error) {
+	return c.writeSnapshot(c.load(), out)
+}
+
+// writeSnapshot writes given snapshot of configuration to a writer.
+func (c *ConfigFile) writeSnapshot(s *snapshot, out io.Writer) error {
+	w := &configWriter{
+		s:         s,
+		buf:       bytes.NewBuffer(nil),
+		equalSign: "=",
+	}
+	if c.prettyFormat {
+		w.equalSign = " = "
+	}
+	w.opts = s.options
+
+	doc := s.doc
+	if doc == nil {
+		doc = newDocument()
+	}
+	w.lineBreak = doc.lineBreak()
+	w.writeDocument(doc)
+	if w.err != nil {
+		return w.err
+	}
+
+	if _, err := w.buf.WriteTo(out); err != nil {
 		return err
 	}
 	return nil
 }
 
-// SaveConfigFile writes configuration file to local file system
+// SaveConfigFile writes configuration file to local file system.
+// It writes to a temporary file in the same directory and renames it
+// to filename, so the file is either fully updated or left as it was.
+// If filename is a symbolic link, the file it links to is updated.
+// Permissions and ownership of the existing file are kept,
+// and a new file is created with permission 0666 before umask like os.Create.
+// If ownership cannot be kept by renaming, e.g. the file is owned by
+// another user but writable by us, the file is overwritten in place.
 func SaveConfigFile(c *ConfigFile, filename string) (err error) {
-	// Write configuration file by filename.
-	var f *os.File
-	if f, err = os.Create(filename); err != nil {
+	return c.swap(func(s *snapshot) (*snapshot, error) {
+		return c.saveFile(s, filename)
+	})
+}
+
+// saveFile writes given snapshot to filename, and returns a copy of it
+// which records the state of file, and is the base of later changes
+// if the file is one of the files loaded.
+func (c *ConfigFile) saveFile(s *snapshot, filename string) (*snapshot, error) {
+	var buf bytes.Buffer
+	if err := c.writeSnapshot(s, &buf); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filename, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	ns := s.clone()
+	ns.fileStats[fileKey(filename)] = newFileStat(filename, buf.Bytes())
+	// Changes saved to other files are still unsaved for loaded files.
+	if ns.isLoaded(filename) {
+		ns.base = ns
+	}
+	return ns, nil
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it to disk,
+// and renames it to filename.
+func writeFileAtomic(filename string, data []byte) (err error) {
+	// Follow symbolic links to update the real file.
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+
+	// Permission of new file is applied umask when it is created.
+	perm := os.FileMode(0666)
+	fi, statErr := os.Stat(filename)
+	if statErr == nil {
+		perm = 0600
+	}
+
+	f, err := createTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp", perm)
+	if err != nil {
+		if statErr == nil && os.IsPermission(err) {
+			// Directory is not writable but the file may be.
+			return writeFileInPlace(filename, data)
+		}
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if statErr == nil {
+		if err = f.Chmod(fi.Mode().Perm()); err != nil {
+			return err
+		}
+		if err = chown(f, fi); os.IsPermission(err) {
+			f.Close()
+			os.Remove(f.Name())
+			return writeFileInPlace(filename, data)
+		} else if err != nil {
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filename); err != nil {
 		return err
 	}
+	return syncDir(filepath.Dir(filename))
+}
+
+// createTemp creates a new file in dir with name starting with prefix
+// and given permission before umask.
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
 
-	if err := SaveConfigData(c, f); err != nil {
+// writeFileInPlace truncates the existing file and writes data to it,
+// which keeps its ownership but is not atomic.
+func writeFileInPlace(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
 	return f.Close()
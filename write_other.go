@@ -0,0 +1,31 @@
+// Copyright 2013 Unknwon
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+//go:build !unix
+
+package goconfig
+
+import (
+	"os"
+)
+
+// chown does nothing, ownership is not kept on this system.
+func chown(f *os.File, fi os.FileInfo) error {
+	return nil
+}
+
+// syncDir does nothing, directories cannot be synced on this system.
+func syncDir(dir string) error {
+	return nil
+}